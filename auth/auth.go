@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes of the endpoints; topics are covered by TopicScope. A granted
+// scope "*" allows everything and a scope ending with ":*" allows every
+// scope with its prefix, e.g. "topic:*".
+const (
+	ScopeTrades = "trades:read"
+	ScopeStream = "stream"
+	ScopeAdmin  = "admin"
+)
+
+// TopicScope is the scope needed to receive messages of the topic
+func TopicScope(topic string) string {
+	return "topic:" + topic
+}
+
+// ErrUnauthenticated is returned for missing, unknown or invalid tokens
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Principal is an authenticated API key or JWT subject. Scopes include
+// those granted by its roles.
+type Principal struct {
+	Subject string
+	Scopes  []string
+	Roles   []string
+	// Exchanges the principal may receive messages of, nil allows any
+	Exchanges []string
+}
+
+// AllowsExchange reports whether the principal may receive messages of the
+// exchange; messages without an exchange are allowed
+func (p *Principal) AllowsExchange(exchange string) bool {
+	if exchange == "" || p.Exchanges == nil {
+		return true
+	}
+	for _, allowed := range p.Exchanges {
+		if strings.EqualFold(allowed, exchange) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsMessage reports whether the principal may receive a message of the
+// topic carrying data of the exchanges. A principal limited to some
+// exchanges needs all of them allowed and gets no message whose exchanges
+// are unknown.
+func (p *Principal) AllowsMessage(topic string, exchanges []string) bool {
+	if !p.Allows(TopicScope(topic)) {
+		return false
+	}
+	if p.Exchanges == nil {
+		return true
+	}
+	if len(exchanges) == 0 {
+		return false
+	}
+	for _, exchange := range exchanges {
+		if !p.AllowsExchange(exchange) {
+			return false
+		}
+	}
+	return true
+}
+
+// Allows reports whether the principal was granted the scope
+func (p *Principal) Allows(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == "*" || granted == scope {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(granted, "*"); ok && strings.HasSuffix(prefix, ":") && strings.HasPrefix(scope, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is a static key with its scopes and roles
+type APIKey struct {
+	Name   string
+	Key    string
+	Scopes []string
+	Roles  []string
+}
+
+// Role bundles scopes, topics and exchanges, e.g. a viewer which may
+// stream order books but not wallet balances. Topics and Exchanges may
+// contain "*"; a principal with roles receives only messages of the
+// exchanges of its roles.
+type Role struct {
+	Scopes    []string
+	Topics    []string
+	Exchanges []string
+}
+
+// Built-in role names
+const (
+	RoleViewer = "viewer"
+	RoleTrader = "trader"
+	RoleAdmin  = "admin"
+)
+
+// Options of the Authenticator. JWTs are accepted if a HS256 secret or a
+// RS256 public key is set; their scopes are read from the space-separated
+// "scope" claim or the "scopes" array claim, their roles from the "roles"
+// array claim or the "role" claim.
+type Options struct {
+	APIKeys []APIKey
+	Roles   map[string]Role
+	// HS256Secret verifies HS256 tokens
+	HS256Secret string
+	// RS256PublicKeyFile is a PEM file verifying RS256 tokens
+	RS256PublicKeyFile string
+	// Issuer and Audience are checked if set
+	Issuer   string
+	Audience string
+}
+
+// Authenticator checks API keys and JWT bearer tokens
+type Authenticator struct {
+	options   Options
+	rsaPublic *rsa.PublicKey
+	parser    *jwt.Parser
+}
+
+func New(options Options) (*Authenticator, error) {
+	a := &Authenticator{options: options}
+	if options.RS256PublicKeyFile != "" {
+		data, err := os.ReadFile(options.RS256PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read RS256 public key: %w", err)
+		}
+		if a.rsaPublic, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+			return nil, fmt.Errorf("failed to parse RS256 public key: %w", err)
+		}
+	}
+
+	var methods []string
+	if options.HS256Secret != "" {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if a.rsaPublic != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	parserOptions := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if options.Issuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(options.Issuer))
+	}
+	if options.Audience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(options.Audience))
+	}
+	a.parser = jwt.NewParser(parserOptions...)
+	return a, nil
+}
+
+// Authenticate returns the principal of an API key or a JWT
+func (a *Authenticator) Authenticate(token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+	for _, key := range a.options.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(token)) == 1 {
+			return a.principal("key:"+key.Name, key.Scopes, key.Roles), nil
+		}
+	}
+	if a.options.HS256Secret == "" && a.rsaPublic == nil {
+		return nil, ErrUnauthenticated
+	}
+
+	var claims tokenClaims
+	_, err := a.parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method == jwt.SigningMethodRS256 {
+			return a.rsaPublic, nil
+		}
+		return []byte(a.options.HS256Secret), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	scopes := strings.Fields(claims.Scope)
+	scopes = append(scopes, claims.Scopes...)
+	roles := claims.Roles
+	if claims.Role != "" {
+		roles = append(roles, claims.Role)
+	}
+	return a.principal(claims.Subject, scopes, roles), nil
+}
+
+// principal grants the scopes, topics and exchanges of the roles; unknown
+// roles grant nothing
+func (a *Authenticator) principal(subject string, scopes, roles []string) *Principal {
+	p := &Principal{
+		Subject: subject,
+		Scopes:  append([]string(nil), scopes...),
+		Roles:   roles,
+	}
+	anyExchange := true
+	for _, name := range roles {
+		role, ok := a.options.Roles[name]
+		if !ok {
+			log.Printf("Unknown role %q of %s", name, subject)
+			continue
+		}
+		p.Scopes = append(p.Scopes, role.Scopes...)
+		for _, topic := range role.Topics {
+			p.Scopes = append(p.Scopes, TopicScope(topic))
+		}
+		if anyExchange {
+			anyExchange = false
+			p.Exchanges = []string{}
+		}
+		p.Exchanges = append(p.Exchanges, role.Exchanges...)
+	}
+	for _, exchange := range p.Exchanges {
+		if exchange == "*" {
+			p.Exchanges = nil
+			break
+		}
+	}
+	return p
+}
+
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope  string   `json:"scope,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+	Role   string   `json:"role,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the principal
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal stored by NewContext
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok
+}
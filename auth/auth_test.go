@@ -0,0 +1,413 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret"
+
+// testRSAKey returns a new RSA key and the path of its public PEM file
+func testRSAKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("marshalling RSA public key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "public.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("writing RSA public key: %v", err)
+	}
+	return key, path
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("signing %s token: %v", method.Alg(), err)
+	}
+	return token
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	a, err := New(Options{APIKeys: []APIKey{
+		{Name: "ops", Key: "ops-key", Scopes: []string{ScopeAdmin}},
+		{Name: "reader", Key: "reader-key", Scopes: []string{ScopeTrades}},
+	}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		subject string
+		scope   string
+	}{
+		{"first key", "ops-key", "key:ops", ScopeAdmin},
+		{"second key", "reader-key", "key:reader", ScopeTrades},
+		{"unknown key", "other-key", "", ""},
+		{"prefix of a key", "ops", "", ""},
+		{"key with a suffix", "ops-key2", "", ""},
+		{"empty token", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := a.Authenticate(tt.token)
+			if tt.subject == "" {
+				if !errors.Is(err, ErrUnauthenticated) {
+					t.Fatalf("err = %v, want ErrUnauthenticated", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if p.Subject != tt.subject || !p.Allows(tt.scope) {
+				t.Errorf("principal = %+v, want %s with %s", p, tt.subject, tt.scope)
+			}
+		})
+	}
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	rsaKey, publicKeyFile := testRSAKey(t)
+	publicPEM, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _ := testRSAKey(t)
+
+	hs256Only := Options{HS256Secret: testSecret}
+	rs256Only := Options{RS256PublicKeyFile: publicKeyFile}
+	both := Options{HS256Secret: testSecret, RS256PublicKeyFile: publicKeyFile}
+	checked := Options{HS256Secret: testSecret, Issuer: "https://issuer", Audience: "cryptobot"}
+
+	now := time.Now()
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"sub": "alice", "exp": now.Add(time.Hour).Unix(), "scope": ScopeStream}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		options Options
+		token   func(t *testing.T) string
+		ok      bool
+	}{
+		{"HS256", hs256Only, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims(nil))
+		}, true},
+		{"RS256", rs256Only, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodRS256, rsaKey, claims(nil))
+		}, true},
+		{"HS256 with both configured", both, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims(nil))
+		}, true},
+		{"RS256 with both configured", both, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodRS256, rsaKey, claims(nil))
+		}, true},
+		{"wrong HS256 secret", hs256Only, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte("other-secret"), claims(nil))
+		}, false},
+		{"wrong RS256 key", rs256Only, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodRS256, otherKey, claims(nil))
+		}, false},
+		{"HS256 when only RS256 is configured", rs256Only, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims(nil))
+		}, false},
+		{"HS256 signed with the RS256 public key", rs256Only, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, publicPEM, claims(nil))
+		}, false},
+		{"RS256 when only HS256 is configured", hs256Only, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodRS256, rsaKey, claims(nil))
+		}, false},
+		{"HS512", hs256Only, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS512, []byte(testSecret), claims(nil))
+		}, false},
+		{"alg none", hs256Only, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(nil))
+		}, false},
+		{"expired", hs256Only, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}))
+		}, false},
+		{"without exp", hs256Only, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims(jwt.MapClaims{"exp": nil}))
+		}, false},
+		{"not yet valid", hs256Only, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims(jwt.MapClaims{"nbf": now.Add(time.Hour).Unix()}))
+		}, false},
+		{"issuer and audience", checked, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims(jwt.MapClaims{"iss": "https://issuer", "aud": []string{"other", "cryptobot"}}))
+		}, true},
+		{"wrong issuer", checked, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims(jwt.MapClaims{"iss": "https://other", "aud": "cryptobot"}))
+		}, false},
+		{"without issuer", checked, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims(jwt.MapClaims{"aud": "cryptobot"}))
+		}, false},
+		{"wrong audience", checked, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims(jwt.MapClaims{"iss": "https://issuer", "aud": "other"}))
+		}, false},
+		{"without audience", checked, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims(jwt.MapClaims{"iss": "https://issuer"}))
+		}, false},
+		{"JWTs disabled", Options{}, func(t *testing.T) string {
+			return sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims(nil))
+		}, false},
+		{"malformed", hs256Only, func(t *testing.T) string {
+			return "not.a.token"
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(tt.options)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			p, err := a.Authenticate(tt.token(t))
+			if !tt.ok {
+				if !errors.Is(err, ErrUnauthenticated) {
+					t.Fatalf("err = %v, want ErrUnauthenticated", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if p.Subject != "alice" || !p.Allows(ScopeStream) {
+				t.Errorf("principal = %+v, want alice with %s", p, ScopeStream)
+			}
+		})
+	}
+}
+
+func TestAuthenticateJWTScopes(t *testing.T) {
+	a, err := New(Options{HS256Secret: testSecret})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	token := sign(t, jwt.SigningMethodHS256, []byte(testSecret), jwt.MapClaims{
+		"sub":    "alice",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"scope":  "stream  trades:read",
+		"scopes": []string{"topic:pnl"},
+	})
+	p, err := a.Authenticate(token)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	want := []string{ScopeStream, ScopeTrades, TopicScope("pnl")}
+	if !slices.Equal(p.Scopes, want) {
+		t.Errorf("scopes = %q, want %q", p.Scopes, want)
+	}
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		granted []string
+		scope   string
+		want    bool
+	}{
+		{[]string{ScopeTrades}, ScopeTrades, true},
+		{[]string{ScopeTrades}, ScopeStream, false},
+		{nil, ScopeStream, false},
+		{[]string{"*"}, ScopeAdmin, true},
+		{[]string{"*"}, TopicScope("wallet"), true},
+		{[]string{"topic:*"}, TopicScope("wallet"), true},
+		{[]string{"topic:*"}, ScopeStream, false},
+		{[]string{"topic:*"}, "topics:wallet", false},
+		// Only whole segments are wildcards
+		{[]string{"trades*"}, ScopeTrades, false},
+		{[]string{"topic:w*"}, TopicScope("wallet"), false},
+		{[]string{ScopeStream, "topic:*"}, TopicScope("pnl"), true},
+		// Scopes are case-sensitive
+		{[]string{"Stream"}, ScopeStream, false},
+	}
+	for _, tt := range tests {
+		p := &Principal{Scopes: tt.granted}
+		if got := p.Allows(tt.scope); got != tt.want {
+			t.Errorf("Principal with %q allows %q = %v, want %v", tt.granted, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/ws", "/ws"},
+		{"/ws?", "/ws?"},
+		{"/ws?access_token=secret", "/ws?access_token=REDACTED"},
+		{"/sse?topics=pnl&access_token=secret&snapshot=1", "/sse?topics=pnl&access_token=REDACTED&snapshot=1"},
+		{"/sse?access_token=a&access_token=b", "/sse?access_token=REDACTED&access_token=REDACTED"},
+		{"/ws?access_token", "/ws?access_token=REDACTED"},
+		{"/ws?access_token=", "/ws?access_token=REDACTED"},
+		// Encoded names are unescaped before comparing
+		{"/ws?access%5Ftoken=secret", "/ws?access%5Ftoken=REDACTED"},
+		// Undecodable names may hide the token
+		{"/ws?access%zztoken=secret", "/ws?access%zztoken=REDACTED"},
+		{"/ws?my_access_token=x&token=y", "/ws?my_access_token=x&token=y"},
+		{"/ws?topics=a=b", "/ws?topics=a=b"},
+	}
+	for _, tt := range tests {
+		if got := RedactQuery(tt.path); got != tt.want {
+			t.Errorf("RedactQuery(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// testRoles are the default roles of the config and two limited to
+// exchanges
+var testRoles = map[string]Role{
+	RoleViewer: {
+		Scopes:    []string{ScopeStream, ScopeTrades},
+		Topics:    []string{"orderbook", "trade"},
+		Exchanges: []string{"*"},
+	},
+	RoleTrader: {
+		Scopes:    []string{ScopeStream, ScopeTrades},
+		Topics:    []string{"orderbook", "trade", "trade_update", "pnl", "wallet"},
+		Exchanges: []string{"*"},
+	},
+	RoleAdmin: {
+		Scopes:    []string{"*"},
+		Topics:    []string{"*"},
+		Exchanges: []string{"*"},
+	},
+	"bybit_trader": {
+		Scopes:    []string{ScopeStream},
+		Topics:    []string{"orderbook", "trade", "wallet"},
+		Exchanges: []string{"BYBIT"},
+	},
+	"mexc_viewer": {
+		Scopes:    []string{ScopeStream},
+		Topics:    []string{"orderbook"},
+		Exchanges: []string{"MEXC"},
+	},
+}
+
+// rolePrincipal authenticates an API key with the roles
+func rolePrincipal(t *testing.T, roles ...string) *Principal {
+	t.Helper()
+	a, err := New(Options{
+		APIKeys: []APIKey{{Name: "test", Key: "test-key", Roles: roles}},
+		Roles:   testRoles,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	p, err := a.Authenticate("test-key")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	return p
+}
+
+func TestRoles(t *testing.T) {
+	tests := []struct {
+		name      string
+		roles     []string
+		exchanges []string
+		allowed   []string
+		denied    []string
+	}{
+		{"viewer", []string{RoleViewer}, nil,
+			[]string{ScopeStream, TopicScope("trade")}, []string{ScopeAdmin, TopicScope("wallet")}},
+		{"admin", []string{RoleAdmin}, nil,
+			[]string{ScopeAdmin, TopicScope("wallet")}, nil},
+		{"one exchange", []string{"bybit_trader"}, []string{"BYBIT"},
+			[]string{TopicScope("wallet")}, []string{ScopeTrades, TopicScope("pnl")}},
+		{"exchanges of the roles are merged", []string{"bybit_trader", "mexc_viewer"}, []string{"BYBIT", "MEXC"},
+			[]string{TopicScope("wallet")}, []string{TopicScope("pnl")}},
+		{"any exchange of one role allows all", []string{"bybit_trader", RoleViewer}, nil,
+			[]string{ScopeTrades, TopicScope("wallet")}, []string{TopicScope("pnl")}},
+		{"unknown role grants nothing", []string{"owner"}, nil,
+			nil, []string{ScopeStream, TopicScope("orderbook")}},
+		{"unknown role next to a known one", []string{"owner", "bybit_trader"}, []string{"BYBIT"},
+			[]string{TopicScope("orderbook")}, []string{ScopeAdmin}},
+		{"no roles", nil, nil,
+			nil, []string{ScopeStream}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := rolePrincipal(t, tt.roles...)
+			if !slices.Equal(p.Exchanges, tt.exchanges) || (p.Exchanges == nil) != (tt.exchanges == nil) {
+				t.Errorf("exchanges = %#v, want %#v", p.Exchanges, tt.exchanges)
+			}
+			for _, scope := range tt.allowed {
+				if !p.Allows(scope) {
+					t.Errorf("%s denied, scopes %q", scope, p.Scopes)
+				}
+			}
+			for _, scope := range tt.denied {
+				if p.Allows(scope) {
+					t.Errorf("%s allowed, scopes %q", scope, p.Scopes)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowsMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		roles     []string
+		topic     string
+		exchanges []string
+		want      bool
+	}{
+		{"viewer order book", []string{RoleViewer}, "orderbook", []string{"BINANCE"}, true},
+		{"viewer wallet", []string{RoleViewer}, "wallet", []string{"BYBIT"}, false},
+		{"viewer trade update", []string{RoleViewer}, "trade_update", []string{"BYBIT"}, false},
+		{"viewer multi-exchange trade", []string{RoleViewer}, "trade", []string{"BYBIT", "MEXC"}, true},
+		{"trader wallet", []string{RoleTrader}, "wallet", []string{"MEXC"}, true},
+		{"trader pnl", []string{RoleTrader}, "pnl", []string{"BINANCE"}, true},
+		{"admin any topic", []string{RoleAdmin}, "trade_update", []string{"BINANCE", "BYBIT"}, true},
+
+		{"exchange-limited role, its exchange", []string{"bybit_trader"}, "wallet", []string{"BYBIT"}, true},
+		{"exchange-limited role, case-insensitive", []string{"bybit_trader"}, "orderbook", []string{"bybit"}, true},
+		{"exchange-limited role, other exchange", []string{"bybit_trader"}, "orderbook", []string{"MEXC"}, false},
+		{"exchange-limited role, multi-exchange trade", []string{"bybit_trader"}, "trade", []string{"BYBIT", "MEXC"}, false},
+		{"exchange-limited role, topic not granted", []string{"bybit_trader"}, "pnl", []string{"BYBIT"}, false},
+		{"merged roles, multi-exchange trade", []string{"bybit_trader", "mexc_viewer"}, "trade", []string{"BYBIT", "MEXC"}, true},
+
+		// A trade with no transactions has no exchanges
+		{"trade without transactions, viewer", []string{RoleViewer}, "trade", nil, true},
+		{"trade without transactions, admin", []string{RoleAdmin}, "trade", nil, true},
+		{"trade without transactions, exchange-limited role", []string{"bybit_trader"}, "trade", nil, false},
+
+		{"unknown role", []string{"owner"}, "orderbook", []string{"BYBIT"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := rolePrincipal(t, tt.roles...)
+			if got := p.AllowsMessage(tt.topic, tt.exchanges); got != tt.want {
+				t.Errorf("AllowsMessage(%s, %q) = %v, want %v", tt.topic, tt.exchanges, got, tt.want)
+			}
+		})
+	}
+}
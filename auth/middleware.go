@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueryParam carries the token of streaming requests, browsers can't set
+// headers on EventSource and WebSocket connections
+const QueryParam = "access_token"
+
+// Token returns the token of the request: "Authorization: Bearer <token>"
+// or "X-API-Key: <key>", and ?access_token= if query is true
+func Token(r *http.Request, query bool) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if query {
+		return r.URL.Query().Get(QueryParam)
+	}
+	return ""
+}
+
+// RedactQuery masks the value of ?access_token= in a path with its query,
+// e.g. for access logs
+func RedactQuery(path string) string {
+	path, rawQuery, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err != nil || name == QueryParam {
+			params[i] = key + "=REDACTED"
+		}
+	}
+	return path + "?" + strings.Join(params, "&")
+}
+
+// Allowed reports whether the principal of the context has the scope.
+// Contexts without a principal come from servers with authentication
+// disabled and allow everything.
+func Allowed(ctx context.Context, scope string) bool {
+	p, ok := FromContext(ctx)
+	return !ok || p.Allows(scope)
+}
+
+// Require rejects requests without a valid header token with the scope.
+// A nil Authenticator means authentication is disabled and lets every
+// request through.
+func (a *Authenticator) Require(scope string) gin.HandlerFunc {
+	return a.middleware(scope, false, false)
+}
+
+// RequireStream is Require for streaming endpoints, the token may also be
+// passed with ?access_token=
+func (a *Authenticator) RequireStream(scope string) gin.HandlerFunc {
+	return a.middleware(scope, true, false)
+}
+
+// Handshake is RequireStream for the WebSocket handshake, except that a
+// request without a token passes unauthenticated: such a client has to
+// send its token in the first frame
+func (a *Authenticator) Handshake(scope string) gin.HandlerFunc {
+	return a.middleware(scope, true, true)
+}
+
+func (a *Authenticator) middleware(scope string, query, optional bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a == nil {
+			c.Next()
+			return
+		}
+		token := Token(c.Request, query)
+		if token == "" && optional {
+			c.Next()
+			return
+		}
+
+		p, err := a.Authenticate(token)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="cryptobot"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if !p.Allows(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "missing scope " + scope})
+			return
+		}
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), p))
+		c.Next()
+	}
+}
@@ -0,0 +1,347 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"cryptobot_server/auth"
+	"cryptobot_server/websocket"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds all settings of the server. Values are taken, from lowest to
+// highest precedence, from the defaults, the config file, CRYPTOBOT_*
+// environment variables and command line flags, see Load.
+type Config struct {
+	Listen      string   `yaml:"listen" toml:"listen"`
+	ExtraListen []string `yaml:"extra_listen" toml:"extra_listen"`
+	// ShutdownTimeout bounds the graceful shutdown after SIGINT or SIGTERM
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// Storage of trades: redis or memory, the latter loses them on restart
+	Storage string `yaml:"storage" toml:"storage"`
+
+	Redis     Redis     `yaml:"redis" toml:"redis"`
+	Kafka     Kafka     `yaml:"kafka" toml:"kafka"`
+	DLQ       DLQ       `yaml:"dlq" toml:"dlq"`
+	Hub       Hub       `yaml:"hub" toml:"hub"`
+	WebSocket WebSocket `yaml:"websocket" toml:"websocket"`
+	SSE       SSE       `yaml:"sse" toml:"sse"`
+	TLS       TLS       `yaml:"tls" toml:"tls"`
+	CORS      CORS      `yaml:"cors" toml:"cors"`
+	Auth      Auth      `yaml:"auth" toml:"auth"`
+}
+
+type Redis struct {
+	Addr     string `yaml:"addr" toml:"addr"`
+	Password string `yaml:"password" toml:"password"`
+	DB       int    `yaml:"db" toml:"db"`
+}
+
+type Kafka struct {
+	Brokers []string `yaml:"brokers" toml:"brokers"`
+	// SASL/PLAIN credentials, empty Username disables SASL
+	Username string `yaml:"username" toml:"username"`
+	Password string `yaml:"password" toml:"password"`
+	GroupID  string `yaml:"group_id" toml:"group_id"`
+	// Topics are consumed by every replica from the newest offset
+	Topics []string `yaml:"topics" toml:"topics"`
+	// GroupTopics are persisted to Redis and consumed through the group
+	GroupTopics []string `yaml:"group_topics" toml:"group_topics"`
+}
+
+type DLQ struct {
+	// Topic is a Kafka topic for dead letters, File is used if it is empty
+	Topic string `yaml:"topic" toml:"topic"`
+	File  string `yaml:"file" toml:"file"`
+}
+
+type Hub struct {
+	ReplaySize int `yaml:"replay_size" toml:"replay_size"`
+}
+
+type WebSocket struct {
+	QueueSize  int    `yaml:"queue_size" toml:"queue_size"`
+	SlowPolicy string `yaml:"slow_policy" toml:"slow_policy"`
+}
+
+type SSE struct {
+	QueueSize int `yaml:"queue_size" toml:"queue_size"`
+}
+
+type TLS struct {
+	Enabled        bool     `yaml:"enabled" toml:"enabled"`
+	Cert           string   `yaml:"cert" toml:"cert"`
+	Key            string   `yaml:"key" toml:"key"`
+	ClientCA       string   `yaml:"client_ca" toml:"client_ca"`
+	ReloadInterval Duration `yaml:"reload_interval" toml:"reload_interval"`
+}
+
+// CORS settings apply to every route; the origins also restrict WebSocket handshakes
+type CORS struct {
+	AllowedOrigins   []string `yaml:"allowed_origins" toml:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods" toml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers" toml:"allowed_headers"`
+	ExposedHeaders   []string `yaml:"exposed_headers" toml:"exposed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials" toml:"allow_credentials"`
+	MaxAge           Duration `yaml:"max_age" toml:"max_age"`
+}
+
+// Auth protects REST routes and streams with API keys and JWTs. Scopes are
+// trades:read, stream, admin and topic:<name>, see package auth. Roles
+// given to API keys or in the roles claim of JWTs add the scopes, topics
+// and exchanges of the role.
+type Auth struct {
+	Enabled bool            `yaml:"enabled" toml:"enabled"`
+	APIKeys []APIKey        `yaml:"api_keys" toml:"api_keys"`
+	JWT     JWT             `yaml:"jwt" toml:"jwt"`
+	Roles   map[string]Role `yaml:"roles" toml:"roles"`
+}
+
+type APIKey struct {
+	Name   string   `yaml:"name" toml:"name"`
+	Key    string   `yaml:"key" toml:"key"`
+	Scopes []string `yaml:"scopes" toml:"scopes"`
+	Roles  []string `yaml:"roles" toml:"roles"`
+}
+
+// Role limits the topics and exchanges streamed to its members, "*" allows
+// any
+type Role struct {
+	Scopes    []string `yaml:"scopes" toml:"scopes"`
+	Topics    []string `yaml:"topics" toml:"topics"`
+	Exchanges []string `yaml:"exchanges" toml:"exchanges"`
+}
+
+type JWT struct {
+	HS256Secret string `yaml:"hs256_secret" toml:"hs256_secret"`
+	// RS256PublicKey is a PEM file
+	RS256PublicKey string `yaml:"rs256_public_key" toml:"rs256_public_key"`
+	Issuer         string `yaml:"issuer" toml:"issuer"`
+	Audience       string `yaml:"audience" toml:"audience"`
+}
+
+// Trade storage backends
+const (
+	StorageRedis  = "redis"
+	StorageMemory = "memory"
+)
+
+// Duration is a time.Duration written as "10s" in config files
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Defaults returns the settings used for local development
+func Defaults() *Config {
+	return &Config{
+		Listen:          "0.0.0.0:8080",
+		ExtraListen:     []string{"0.0.0.0:10999"},
+		ShutdownTimeout: Duration(15 * time.Second),
+		Storage:         StorageRedis,
+		Redis: Redis{
+			Addr: "localhost:6379",
+		},
+		Kafka: Kafka{
+			Brokers:     []string{"localhost:19092"},
+			GroupID:     "cryptobot_server",
+			Topics:      []string{"orderbook", "pnl", "wallet", "trade"},
+			GroupTopics: []string{"trade_dictionary"},
+		},
+		DLQ: DLQ{
+			File: "dead_letters.jsonl",
+		},
+		Hub: Hub{
+			ReplaySize: 1024,
+		},
+		WebSocket: WebSocket{
+			QueueSize:  256,
+			SlowPolicy: websocket.PolicyDropOldest,
+		},
+		SSE: SSE{
+			QueueSize: 256,
+		},
+		TLS: TLS{
+			ReloadInterval: Duration(10 * time.Second),
+		},
+		CORS: CORS{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "POST", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "Accept", "Authorization", "X-API-Key"},
+			ExposedHeaders: []string{"X-Next-Cursor"},
+			MaxAge:         Duration(10 * time.Minute),
+		},
+		Auth: Auth{
+			Roles: map[string]Role{
+				auth.RoleViewer: {
+					Scopes:    []string{auth.ScopeStream, auth.ScopeTrades},
+					Topics:    []string{"orderbook", "trade"},
+					Exchanges: []string{"*"},
+				},
+				auth.RoleTrader: {
+					Scopes:    []string{auth.ScopeStream, auth.ScopeTrades},
+					Topics:    []string{"orderbook", "trade", "trade_update", "pnl", "wallet"},
+					Exchanges: []string{"*"},
+				},
+				auth.RoleAdmin: {
+					Scopes:    []string{"*"},
+					Topics:    []string{"*"},
+					Exchanges: []string{"*"},
+				},
+			},
+		},
+	}
+}
+
+// LoadFile merges a YAML (.yaml, .yml) or TOML (.toml) file into c
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("unsupported config file format: %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the settings, all problems are reported at once
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Listen == "" {
+		errs = append(errs, errors.New("listen must not be empty"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+	switch c.Storage {
+	case StorageRedis:
+		if c.Redis.Addr == "" {
+			errs = append(errs, errors.New("redis.addr must not be empty"))
+		}
+	case StorageMemory:
+	default:
+		errs = append(errs, fmt.Errorf("storage must be %s or %s: %q", StorageRedis, StorageMemory, c.Storage))
+	}
+	if c.Redis.DB < 0 {
+		errs = append(errs, fmt.Errorf("redis.db must not be negative: %d", c.Redis.DB))
+	}
+	if len(c.Kafka.Brokers) == 0 {
+		errs = append(errs, errors.New("kafka.brokers must not be empty"))
+	}
+	if len(c.Kafka.GroupTopics) > 0 && c.Kafka.GroupID == "" {
+		errs = append(errs, errors.New("kafka.group_id must be set when kafka.group_topics are used"))
+	}
+	if c.Kafka.Username != "" && c.Kafka.Password == "" {
+		errs = append(errs, errors.New("kafka.password must be set with kafka.username"))
+	}
+	if c.DLQ.Topic == "" && c.DLQ.File == "" {
+		errs = append(errs, errors.New("either dlq.topic or dlq.file must be set"))
+	}
+	if c.Hub.ReplaySize < 0 {
+		errs = append(errs, fmt.Errorf("hub.replay_size must not be negative: %d", c.Hub.ReplaySize))
+	}
+	if c.WebSocket.QueueSize < 1 {
+		errs = append(errs, fmt.Errorf("websocket.queue_size must be positive: %d", c.WebSocket.QueueSize))
+	}
+	if err := websocket.ValidatePolicy(c.WebSocket.SlowPolicy); err != nil {
+		errs = append(errs, fmt.Errorf("websocket.slow_policy: %w", err))
+	}
+	if c.SSE.QueueSize < 1 {
+		errs = append(errs, fmt.Errorf("sse.queue_size must be positive: %d", c.SSE.QueueSize))
+	}
+	if len(c.CORS.AllowedMethods) == 0 {
+		errs = append(errs, errors.New("cors.allowed_methods must not be empty"))
+	}
+	if c.CORS.AllowCredentials && slices.Contains(c.CORS.AllowedOrigins, "*") {
+		errs = append(errs, errors.New("cors.allow_credentials needs explicit cors.allowed_origins instead of *"))
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("cors.max_age must not be negative"))
+	}
+	if c.Auth.Enabled {
+		if len(c.Auth.APIKeys) == 0 && c.Auth.JWT.HS256Secret == "" && c.Auth.JWT.RS256PublicKey == "" {
+			errs = append(errs, errors.New("auth needs api_keys, jwt.hs256_secret or jwt.rs256_public_key"))
+		}
+		keys := make(map[string]bool)
+		for i, key := range c.Auth.APIKeys {
+			if key.Name == "" || key.Key == "" {
+				errs = append(errs, fmt.Errorf("auth.api_keys[%d] needs a name and a key", i))
+			}
+			if keys[key.Key] {
+				errs = append(errs, fmt.Errorf("auth.api_keys[%d] repeats the key of another entry", i))
+			}
+			keys[key.Key] = true
+			for _, role := range key.Roles {
+				if _, ok := c.Auth.Roles[role]; !ok {
+					errs = append(errs, fmt.Errorf("auth.api_keys[%d] has unknown role %q", i, role))
+				}
+			}
+		}
+		for name, role := range c.Auth.Roles {
+			if len(role.Exchanges) == 0 {
+				errs = append(errs, fmt.Errorf("auth.roles.%s needs exchanges, use \"*\" for any", name))
+			}
+		}
+	}
+	if c.TLS.Enabled {
+		if c.TLS.Cert == "" || c.TLS.Key == "" {
+			errs = append(errs, errors.New("tls.cert and tls.key must be set when tls is enabled"))
+		}
+		if c.TLS.ReloadInterval <= 0 {
+			errs = append(errs, errors.New("tls.reload_interval must be positive"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy with secrets masked, for printing
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	if redacted.Redis.Password != "" {
+		redacted.Redis.Password = "******"
+	}
+	if redacted.Kafka.Password != "" {
+		redacted.Kafka.Password = "******"
+	}
+	if redacted.Auth.JWT.HS256Secret != "" {
+		redacted.Auth.JWT.HS256Secret = "******"
+	}
+	redacted.Auth.APIKeys = make([]APIKey, len(c.Auth.APIKeys))
+	for i, key := range c.Auth.APIKeys {
+		key.Key = "******"
+		redacted.Auth.APIKeys[i] = key
+	}
+	return &redacted
+}
+
+// YAML returns the config in the format of a config file
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is prepended to the upper-cased flag name to get the environment
+// variable of a setting, e.g. -redis-addr is CRYPTOBOT_REDIS_ADDR
+const EnvPrefix = "CRYPTOBOT_"
+
+// field binds a setting to its flag and environment variable
+type field struct {
+	name  string
+	usage string
+	ptr   any
+}
+
+func fields(c *Config) []field {
+	return []field{
+		{"listen", "Address of the HTTP server for REST, /ws and /stream", &c.Listen},
+		{"extra-listen", "Comma-separated extra addresses serving the same routes, for old clients of the separate WebSocket port", &c.ExtraListen},
+		{"shutdown-timeout", "How long to wait for connections and Kafka handlers on shutdown", &c.ShutdownTimeout},
+		{"storage", "Where trades are stored: redis or memory", &c.Storage},
+		{"redis-addr", "Redis address", &c.Redis.Addr},
+		{"redis-password", "Redis password", &c.Redis.Password},
+		{"redis-db", "Redis database number", &c.Redis.DB},
+		{"kafka-brokers", "Comma-separated Kafka brokers", &c.Kafka.Brokers},
+		{"kafka-username", "Kafka SASL/PLAIN user, empty disables SASL", &c.Kafka.Username},
+		{"kafka-password", "Kafka SASL/PLAIN password", &c.Kafka.Password},
+		{"kafka-group", "Kafka consumer group for topics persisted to Redis", &c.Kafka.GroupID},
+		{"kafka-topics", "Comma-separated topics streamed to clients", &c.Kafka.Topics},
+		{"kafka-group-topics", "Comma-separated topics consumed through the consumer group", &c.Kafka.GroupTopics},
+		{"dlq-topic", "Kafka topic for undecodable messages; a local file is used if empty", &c.DLQ.Topic},
+		{"dlq-file", "File for undecodable messages when -dlq-topic is not set", &c.DLQ.File},
+		{"replay-size", "Messages per topic kept for resuming clients", &c.Hub.ReplaySize},
+		{"ws-queue-size", "Max data frames queued for a slow WebSocket client", &c.WebSocket.QueueSize},
+		{"ws-slow-policy", "What to do when a WebSocket client queue is full: drop_oldest, coalesce or disconnect", &c.WebSocket.SlowPolicy},
+		{"sse-queue-size", "Max events queued for an SSE client before its stream is closed", &c.SSE.QueueSize},
+		{"cors-origins", "Comma-separated origins allowed for CORS and WebSocket handshakes; * allows any, https://*.example.com any subdomain", &c.CORS.AllowedOrigins},
+		{"cors-methods", "Comma-separated methods allowed in CORS preflight responses", &c.CORS.AllowedMethods},
+		{"cors-headers", "Comma-separated request headers allowed in CORS preflight responses", &c.CORS.AllowedHeaders},
+		{"cors-expose-headers", "Comma-separated response headers exposed to browsers", &c.CORS.ExposedHeaders},
+		{"cors-credentials", "Allow cookies and credentials in CORS requests, needs explicit origins", &c.CORS.AllowCredentials},
+		{"cors-max-age", "How long browsers may cache CORS preflight responses", &c.CORS.MaxAge},
+		{"auth", "Require API keys or JWTs on all routes; API keys are set in the config file", &c.Auth.Enabled},
+		{"auth-jwt-hs256-secret", "Secret verifying HS256 JWTs", &c.Auth.JWT.HS256Secret},
+		{"auth-jwt-rs256-public-key", "PEM public key file verifying RS256 JWTs", &c.Auth.JWT.RS256PublicKey},
+		{"auth-jwt-issuer", "Required iss claim of JWTs", &c.Auth.JWT.Issuer},
+		{"auth-jwt-audience", "Required aud claim of JWTs", &c.Auth.JWT.Audience},
+		{"tls", "Serve HTTPS and WSS instead of plain HTTP, needs -tls-cert and -tls-key", &c.TLS.Enabled},
+		{"tls-cert", "TLS certificate file", &c.TLS.Cert},
+		{"tls-key", "TLS private key file", &c.TLS.Key},
+		{"tls-client-ca", "CA file for verifying client certificates, enables mutual TLS", &c.TLS.ClientCA},
+		{"tls-reload-interval", "How often TLS files are checked for changes", &c.TLS.ReloadInterval},
+	}
+}
+
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load builds the config from the defaults, the file given with -config or
+// CRYPTOBOT_CONFIG, the environment and the flags in args, in this order of
+// precedence, and validates it. printConfig reports whether -print-config
+// was given.
+func Load(args []string) (cfg *Config, printConfig bool, err error) {
+	// Flags are parsed first to find -config, their values are applied last
+	fs := flag.NewFlagSet("cryptobot_server", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "YAML or TOML config file")
+	fs.BoolVar(&printConfig, "print-config", false, "Print the effective config and exit")
+	for _, f := range fields(Defaults()) {
+		fs.Var(value{f.ptr}, f.name, f.usage+" (env "+envName(f.name)+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	cfg = Defaults()
+	if *configPath != "" {
+		if err := cfg.LoadFile(*configPath); err != nil {
+			return nil, false, err
+		}
+	}
+
+	byName := make(map[string]field)
+	for _, f := range fields(cfg) {
+		byName[f.name] = f
+		if s, ok := os.LookupEnv(envName(f.name)); ok {
+			if err := (value{f.ptr}).Set(s); err != nil {
+				return nil, false, fmt.Errorf("invalid %s: %w", envName(f.name), err)
+			}
+		}
+	}
+
+	fs.Visit(func(fl *flag.Flag) {
+		f, ok := byName[fl.Name]
+		if !ok || err != nil {
+			return
+		}
+		if setErr := (value{f.ptr}).Set(fl.Value.String()); setErr != nil {
+			err = fmt.Errorf("invalid -%s: %w", fl.Name, setErr)
+		}
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, false, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, printConfig, nil
+}
+
+// value implements flag.Value over a pointer to a config field
+type value struct {
+	ptr any
+}
+
+func (v value) String() string {
+	switch p := v.ptr.(type) {
+	case nil:
+		return ""
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *Duration:
+		return time.Duration(*p).String()
+	case *[]string:
+		return strings.Join(*p, ",")
+	}
+	return fmt.Sprintf("%v", v.ptr)
+}
+
+func (v value) Set(s string) error {
+	switch p := v.ptr.(type) {
+	case *string:
+		*p = s
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*p = b
+	case *Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*p = Duration(d)
+	case *[]string:
+		*p = nil
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported setting type %T", v.ptr)
+	}
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given as -tls without a value
+func (v value) IsBoolFlag() bool {
+	_, ok := v.ptr.(*bool)
+	return ok
+}
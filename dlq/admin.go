@@ -0,0 +1,58 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReplayFunc processes a dead letter again with its topic handler
+type ReplayFunc func(ctx context.Context, entry Entry) error
+
+// ListHandler returns all dead letters: GET /admin/dlq
+func ListHandler(q Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entries, err := q.List()
+		if err != nil {
+			log.Printf("Error listing dead letters: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// ReplayHandler processes a dead letter again and removes it from the queue
+// on success: POST /admin/dlq/:id/replay
+func ReplayHandler(q Queue, replay ReplayFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		entry, err := Get(q, id)
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching dead letter %s: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := replay(c.Request.Context(), entry); err != nil {
+			log.Printf("Replay of dead letter %s failed: %v", id, err)
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := q.Remove(id); err != nil {
+			log.Printf("Error removing replayed dead letter %s: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Dead letter %s replayed", id)
+		c.JSON(http.StatusOK, entry)
+	}
+}
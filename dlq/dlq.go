@@ -0,0 +1,59 @@
+package dlq
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned when there is no entry with the requested ID
+var ErrNotFound = errors.New("dead letter not found")
+
+// Entry is a message which could not be processed by its topic handler
+type Entry struct {
+	ID        string    `json:"id"`
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Error     string    `json:"error"`
+	Payload   []byte    `json:"payload"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// NewEntry builds an entry identified by the position of the message in
+// Kafka. timestamp is the time of the message, replays pass it on to the
+// handler.
+func NewEntry(topic string, partition int32, offset int64, timestamp time.Time, payload []byte, err error) Entry {
+	return Entry{
+		ID:        fmt.Sprintf("%s-%d-%d", topic, partition, offset),
+		Topic:     topic,
+		Partition: partition,
+		Offset:    offset,
+		Timestamp: timestamp,
+		Error:     err.Error(),
+		Payload:   payload,
+		FailedAt:  time.Now().UTC(),
+	}
+}
+
+// Queue stores dead letters until they are replayed
+type Queue interface {
+	Put(entry Entry) error
+	List() ([]Entry, error)
+	Remove(id string) error
+}
+
+// Get looks up an entry by ID
+func Get(q Queue, id string) (Entry, error) {
+	entries, err := q.List()
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return Entry{}, ErrNotFound
+}
@@ -0,0 +1,111 @@
+package dlq
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileQueue keeps dead letters in a local file, one JSON entry per line
+type FileQueue struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileQueue(path string) *FileQueue {
+	return &FileQueue{path: path}
+}
+
+func (q *FileQueue) Put(entry Entry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	f, err := os.OpenFile(q.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	return nil
+}
+
+func (q *FileQueue) List() ([]Entry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.read()
+}
+
+func (q *FileQueue) Remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries, err := q.read()
+	if err != nil {
+		return err
+	}
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.ID != id {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == len(entries) {
+		return ErrNotFound
+	}
+
+	// Rewrite the file through a temporary one so a crash doesn't lose entries
+	tmp := q.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create dead letter file: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, entry := range kept {
+		if err := enc.Encode(entry); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write dead letter: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write dead letter file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write dead letter file: %w", err)
+	}
+	return os.Rename(tmp, q.path)
+}
+
+func (q *FileQueue) read() ([]Entry, error) {
+	f, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var entry Entry
+		if err := dec.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("failed to read dead letter file: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
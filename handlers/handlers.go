@@ -3,74 +3,220 @@ package handlers
 import (
 	"context"
 	"cryptobot_server/aot"
-	"cryptobot_server/redis"
+	"cryptobot_server/hub"
+	"cryptobot_server/store"
 	"fmt"
 	"log"
-	"strconv"
+	"slices"
+	"sort"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 )
 
-var ctx = context.Background()
-
-var TopicHandlers = map[string]func(chan []byte, interface{}){
-	"orderbook":        handleOrderBook,
-	"pnl":              handlePNL,
-	"wallet":           handleWallet,
-	"trade":            handleTrade,
-	"trade_dictionary": handleTradeDictionary,
+// TradeUpdateTopic is the hub topic announcing stored trades whose
+// transactions were changed by a later trade_dictionary message. Its
+// events carry the whole trade as stored after the change.
+const TradeUpdateTopic = "trade_update"
+
+// NewDefaultRegistry returns a registry with the handlers of all built-in
+// topics; trade_dictionary is persisted to trades and its changes are
+// published to TradeUpdateTopic
+func NewDefaultRegistry(pub Publisher, trades store.TradeStore) *Registry {
+	r := NewRegistry()
+	r.Register("orderbook", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return handleOrderBook(pub, msg)
+	}), "orderbook")
+	r.Register("pnl", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return handlePNL(pub, msg)
+	}), "pnl")
+	r.Register("wallet", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return handleWallet(pub, msg)
+	}), "wallet")
+	r.Register("trade", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return handleTrade(pub, msg)
+	}), "trade")
+	r.Register("trade_dictionary", HandlerFunc(func(ctx context.Context, msg Message) error {
+		return handleTradeDictionary(ctx, pub, trades, msg)
+	}), TradeUpdateTopic)
+	return r
 }
 
-func handleOrderBook(messageChannel chan []byte, data interface{}) {
-	log.Println("Handling orderbook data:", data)
-	messageChannel <- data.([]byte)
-}
+func handleOrderBook(pub Publisher, msg Message) error {
+	log.Println("Handling orderbook data:", msg.Value)
+
+	var orderBook aot.OrderBook
+	if err := proto.Unmarshal(msg.Value, &orderBook); err != nil {
+		return Permanent(fmt.Errorf("failed to unmarshal orderbook: %w", err))
+	}
 
-func handlePNL(messageChannel chan []byte, data interface{}) {
-	log.Println("Handling PNL data:", data)
-	messageChannel <- data.([]byte)
+	pub.Publish(hub.Event{
+		Topic: msg.Topic,
+		Instrument: hub.Instrument{
+			Exchange:    orderBook.Exchange,
+			MarketType:  orderBook.MarketType,
+			TradingPair: orderBook.TradingPair,
+		},
+		Key:     hub.Key(orderBook.Exchange, orderBook.MarketType, orderBook.TradingPair),
+		Time:    msg.Timestamp,
+		Message: &orderBook,
+		Data:    msg.Value,
+	})
+	return nil
 }
 
-func handleWallet(messageChannel chan []byte, data interface{}) {
-	log.Println("Handling wallet data:", data)
-	messageChannel <- data.([]byte)
+func handlePNL(pub Publisher, msg Message) error {
+	log.Println("Handling PNL data:", msg.Value)
+
+	var pnl aot.Pnl
+	if err := proto.Unmarshal(msg.Value, &pnl); err != nil {
+		return Permanent(fmt.Errorf("failed to unmarshal PNL: %w", err))
+	}
+
+	pub.Publish(hub.Event{
+		Topic: msg.Topic,
+		Instrument: hub.Instrument{
+			Exchange:    pnl.Exchange,
+			TradingPair: pnl.TradingPair,
+		},
+		Key:     hub.Key(pnl.Exchange, pnl.TradingPair),
+		Time:    msg.Timestamp,
+		Message: &pnl,
+		Data:    msg.Value,
+	})
+	return nil
 }
 
-func handleTrade(messageChannel chan []byte, data interface{}) {
-	log.Println("Handling trade data:", data)
-	messageChannel <- data.([]byte)
+func handleWallet(pub Publisher, msg Message) error {
+	log.Println("Handling wallet data:", msg.Value)
+
+	var wallet aot.Wallet
+	if err := proto.Unmarshal(msg.Value, &wallet); err != nil {
+		return Permanent(fmt.Errorf("failed to unmarshal wallet: %w", err))
+	}
+
+	pub.Publish(hub.Event{
+		Topic: msg.Topic,
+		Instrument: hub.Instrument{
+			Exchange: wallet.Exchange,
+		},
+		Key:     hub.Key(wallet.Exchange, wallet.Ticker),
+		Time:    msg.Timestamp,
+		Message: &wallet,
+		Data:    msg.Value,
+	})
+	return nil
 }
 
-func handleTradeDictionary(messageChannel chan []byte, data interface{}) {
-	log.Println("Handling TradeDictionary message:", data)
+func handleTrade(pub Publisher, msg Message) error {
+	log.Println("Handling trade data:", msg.Value)
 
-	var trades aot.Trades
+	var trade aot.Trade
+	if err := proto.Unmarshal(msg.Value, &trade); err != nil {
+		return Permanent(fmt.Errorf("failed to unmarshal trade: %w", err))
+	}
+
+	pub.Publish(hub.Event{
+		Topic:     msg.Topic,
+		Exchanges: tradeExchanges(&trade),
+		Time:      msg.Timestamp,
+		Message:   &trade,
+		Data:      msg.Value,
+	})
+	return nil
+}
 
-	bytes, ok := data.([]byte)
-	if !ok {
-		log.Println("Type assertion failed for TradeDictionary")
-		return
+// tradeExchanges returns the sorted exchanges of the transactions of the
+// trade, subscribers limited to some exchanges are checked against them
+func tradeExchanges(trade *aot.Trade) []string {
+	var exchanges []string
+	for _, transaction := range trade.Transactions {
+		exchange := transaction.ExchangeId.String()
+		if !slices.Contains(exchanges, exchange) {
+			exchanges = append(exchanges, exchange)
+		}
 	}
+	sort.Strings(exchanges)
+	return exchanges
+}
+
+func handleTradeDictionary(ctx context.Context, pub Publisher, trades store.TradeStore, msg Message) error {
+	log.Println("Handling TradeDictionary message:", msg.Value)
 
-	if err := proto.Unmarshal(bytes, &trades); err != nil {
-		log.Fatalf("Failed to unmarshal: %v", err)
+	var dictionary aot.Trades
+	if err := proto.Unmarshal(msg.Value, &dictionary); err != nil {
+		return Permanent(fmt.Errorf("failed to unmarshal TradeDictionary: %w", err))
 	}
 
-	for tradeID, trade := range trades.Trades {
+	batch := make([]*aot.Trade, 0, len(dictionary.Trades))
+	for tradeID, trade := range dictionary.Trades {
 		fmt.Printf("Trade ID: %d\n", tradeID)
-		for idTransaction, transaction := range trade.Transactions {
+		for _, transaction := range trade.Transactions {
 			fmt.Printf("  Trading Pair: %s\n", transaction.TradingPair)
 			fmt.Printf("  Exchange: %s\n", transaction.ExchangeId.String())
 			fmt.Printf("  Market Type: %s\n", transaction.MarketType.String())
 			fmt.Printf("  Transaction Action: %s\n\n", transaction.TransactionAction.String())
+		}
+
+		// Ключ словаря и есть идентификатор трейда
+		trade.Id = tradeID
+		batch = append(batch, trade)
+	}
 
-			redisKey := fmt.Sprintf("trade:%d:transaction:%d", tradeID, idTransaction)
-			redis.SaveTransactionToRedis(redisKey, transaction)
+	// Время сообщения в Kafka служит временем изменения: транзакции из более
+	// старых сообщений не перезаписывают более новые. Без времени сообщение
+	// могло бы затереть более новые данные, поэтому оно не принимается
+	updatedAt := msg.Timestamp
+	if updatedAt.IsZero() {
+		return Permanent(fmt.Errorf("trade_dictionary message at partition %d offset %d has no timestamp", msg.Partition, msg.Offset))
+	}
+
+	// Все трейды сообщения записываются за один запрос
+	sort.Slice(batch, func(i, j int) bool { return batch[i].Id < batch[j].Id })
+	changes, err := trades.SaveTrades(ctx, batch, updatedAt)
+	if err != nil {
+		return err
+	}
 
-			redis.AddTransactionToListIfNotExists(fmt.Sprintf("trade:%s:transactions", strconv.FormatUint(tradeID, 10)), redisKey)
+	var updated []uint64
+	for _, change := range changes {
+		switch change.Status {
+		case store.Stale:
+			log.Printf("Rejected stale transaction %d of trade %d: stored version %d is from %s, message is from %s",
+				change.Index, change.TradeID, change.Version, change.UpdatedAt.Format(time.RFC3339Nano), updatedAt.Format(time.RFC3339Nano))
+		case store.Updated:
+			log.Printf("Transaction %d of trade %d updated to version %d", change.Index, change.TradeID, change.Version)
+			if len(updated) == 0 || updated[len(updated)-1] != change.TradeID {
+				updated = append(updated, change.TradeID)
+			}
+		}
+	}
 
+	// Подписчики получают трейд целиком в том виде, в каком он сохранен.
+	// Ключа у события нет, как и у trade: в снимок топика попадали бы все
+	// когда-либо измененные трейды
+	for _, tradeID := range updated {
+		// Изменения уже сохранены, повтор сообщения событие не вернет, поэтому
+		// ошибка только логируется
+		trade, err := trades.GetTrade(ctx, tradeID)
+		if err != nil {
+			log.Printf("Error reading updated trade %d: %v", tradeID, err)
+			continue
+		}
+		data, err := proto.Marshal(trade)
+		if err != nil {
+			log.Printf("Error marshalling updated trade %d: %v", tradeID, err)
+			continue
 		}
+		pub.Publish(hub.Event{
+			Topic:     TradeUpdateTopic,
+			Exchanges: tradeExchanges(trade),
+			Time:      updatedAt,
+			Message:   trade,
+			Data:      data,
+		})
 	}
 
 	log.Println("Successfully processed TradeDictionary message")
+	return nil
 }
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"cryptobot_server/hub"
+)
+
+// Message is a Kafka message passed to a topic handler
+type Message struct {
+	Topic     string
+	Key       []byte
+	Value     []byte
+	Headers   map[string][]byte
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+}
+
+// Handler processes messages of one topic. A returned error is reported
+// to the consumer, which retries the message or routes it to the
+// dead-letter queue, see Permanent.
+type Handler interface {
+	Handle(ctx context.Context, msg Message) error
+}
+
+// HandlerFunc adapts a function to the Handler interface
+type HandlerFunc func(ctx context.Context, msg Message) error
+
+func (f HandlerFunc) Handle(ctx context.Context, msg Message) error {
+	return f(ctx, msg)
+}
+
+// Publisher receives decoded events, normally the hub
+type Publisher interface {
+	Publish(ev hub.Event)
+}
+
+// permanentError marks errors which retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error which retrying won't fix, such as an undecodable
+// payload; the consumer sends such messages to the dead-letter queue at once.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was wrapped with Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Registry maps topics to their handlers and records the hub topics each
+// handler publishes to
+type Registry struct {
+	mu        sync.RWMutex
+	handlers  map[string]Handler
+	publishes map[string][]string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers:  make(map[string]Handler),
+		publishes: make(map[string][]string),
+	}
+}
+
+// Register sets the handler of a topic, replacing a previous one.
+// hubTopics are the hub topics the handler publishes to.
+func (r *Registry) Register(topic string, handler Handler, hubTopics ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[topic] = handler
+	r.publishes[topic] = hubTopics
+}
+
+// Lookup returns the handler of a topic
+func (r *Registry) Lookup(topic string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	handler, ok := r.handlers[topic]
+	return handler, ok
+}
+
+// Topics returns the sorted list of registered topics
+func (r *Registry) Topics() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	topics := make([]string, 0, len(r.handlers))
+	for topic := range r.handlers {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// HubTopics returns the sorted list of hub topics the registered handlers
+// publish to
+func (r *Registry) HubTopics() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var topics []string
+	for _, hubTopics := range r.publishes {
+		for _, topic := range hubTopics {
+			if !slices.Contains(topics, topic) {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
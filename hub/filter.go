@@ -0,0 +1,59 @@
+package hub
+
+import "strings"
+
+// Instrument identifies what a message is about. Fields the message
+// doesn't carry are left empty.
+type Instrument struct {
+	Exchange    string
+	MarketType  string
+	TradingPair string
+}
+
+// Filter is a subscription predicate. Empty fields match anything, and a
+// field is only checked when the message carries that dimension, so a
+// trading pair filter doesn't hide messages that have no trading pair.
+type Filter struct {
+	Exchange    string `json:"exchange,omitempty"`
+	MarketType  string `json:"market_type,omitempty"`
+	TradingPair string `json:"trading_pair,omitempty"`
+}
+
+// Match reports whether the event passes the filter. An event without an
+// instrument exchange, e.g. a trade, passes an exchange filter if one of
+// its exchanges matches. Comparison is case-insensitive.
+func (f Filter) Match(ev *Event) bool {
+	return f.matchExchange(ev) &&
+		matchField(f.MarketType, ev.Instrument.MarketType) &&
+		matchField(f.TradingPair, ev.Instrument.TradingPair)
+}
+
+func (f Filter) matchExchange(ev *Event) bool {
+	if f.Exchange == "" || ev.Instrument.Exchange != "" || len(ev.Exchanges) == 0 {
+		return matchField(f.Exchange, ev.Instrument.Exchange)
+	}
+	for _, exchange := range ev.Exchanges {
+		if strings.EqualFold(f.Exchange, exchange) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchField(want, got string) bool {
+	return want == "" || got == "" || strings.EqualFold(want, got)
+}
+
+// matchAny reports whether the event passes at least one of the filters;
+// no filters means everything passes.
+func matchAny(filters []Filter, ev *Event) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if f.Match(ev) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cryptobot_server/aot"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var jsonOptions = protojson.MarshalOptions{
+	UseProtoNames:   true,
+	EmitUnpopulated: true,
+}
+
+// Frame is an event encoded once for all subscribers
+type Frame struct {
+	Event
+	// JSON envelope: {"topic": ..., "type": ..., "seq": ..., "epoch": ..., "ts": ..., "payload": {...}}
+	JSON []byte
+	// Protobuf aot.Envelope with the same fields
+	Binary []byte
+}
+
+type envelope struct {
+	Topic   string          `json:"topic"`
+	Type    string          `json:"type"`
+	Seq     uint64          `json:"seq"`
+	Epoch   string          `json:"epoch"`
+	Ts      int64           `json:"ts"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type snapshotEnvelope struct {
+	Topic   string            `json:"topic"`
+	Type    string            `json:"type"`
+	Seq     uint64            `json:"seq"`
+	Epoch   string            `json:"epoch"`
+	Ts      int64             `json:"ts"`
+	Payload []json.RawMessage `json:"payload"`
+}
+
+// SnapshotJSON builds the JSON snapshot frame of a topic; its payload is
+// the list of the latest envelopes and seq is the sequence number of the
+// topic in the epoch when the snapshot was taken:
+//
+//	{"topic": ..., "type": "snapshot", "seq": ..., "epoch": ..., "ts": ..., "payload": [{envelope}, ...]}
+func SnapshotJSON(topic, epoch string, seq uint64, frames []*Frame) ([]byte, error) {
+	payload := make([]json.RawMessage, len(frames))
+	for i, frame := range frames {
+		payload[i] = frame.JSON
+	}
+	return json.Marshal(snapshotEnvelope{
+		Topic:   topic,
+		Type:    "snapshot",
+		Seq:     seq,
+		Epoch:   epoch,
+		Ts:      time.Now().UnixMilli(),
+		Payload: payload,
+	})
+}
+
+// encodePayload converts the decoded payload to JSON, done before the
+// sequence number is known to keep the expensive part out of the hub lock
+func encodePayload(ev Event) (json.RawMessage, error) {
+	if ev.Message == nil {
+		return json.RawMessage("null"), nil
+	}
+	payload, err := jsonOptions.Marshal(ev.Message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s payload to JSON: %w", ev.Topic, err)
+	}
+	return payload, nil
+}
+
+func newFrame(ev Event, payload json.RawMessage) (*Frame, error) {
+	data, err := json.Marshal(envelope{
+		Topic:   ev.Topic,
+		Type:    ev.Type,
+		Seq:     ev.Seq,
+		Epoch:   ev.Epoch,
+		Ts:      ev.Time.UnixMilli(),
+		Payload: payload,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s envelope: %w", ev.Topic, err)
+	}
+
+	binary, err := proto.Marshal(&aot.Envelope{
+		Topic:   ev.Topic,
+		Type:    ev.Type,
+		Ts:      ev.Time.UnixMilli(),
+		Payload: ev.Data,
+		Seq:     ev.Seq,
+		Epoch:   ev.Epoch,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s binary envelope: %w", ev.Topic, err)
+	}
+
+	return &Frame{
+		Event:  ev,
+		JSON:   data,
+		Binary: binary,
+	}, nil
+}
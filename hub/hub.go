@@ -0,0 +1,288 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Subscriber receives frames broadcast by the hub.
+// Send is called from the publishing goroutine and must not block:
+// slow subscribers are expected to queue or drop frames on their own.
+type Subscriber interface {
+	Send(frame *Frame)
+	// SendSnapshot delivers the latest frame of every key of the topic
+	// which passes the subscription filters, ordered by key; seq is the
+	// sequence number of the topic when the snapshot was taken in the run
+	// of the hub named by epoch
+	SendSnapshot(topic, epoch string, seq uint64, frames []*Frame)
+}
+
+// Bounded is implemented by subscribers which queue frames in a bounded
+// queue. Room returns how many more frames fit; Resume requires a snapshot
+// instead of replaying more frames than that, since dropping some of them
+// would leave a gap the client doesn't know about.
+type Bounded interface {
+	Room() int
+}
+
+// Event is a decoded message of a topic ready to be fanned out.
+type Event struct {
+	Topic      string
+	Type       string // name of the payload message, filled by Publish
+	Seq        uint64 // per-topic sequence number, filled by Publish
+	Epoch      string // run of the hub the sequence number belongs to, filled by Publish
+	Time       time.Time
+	Instrument Instrument
+	// Exchanges of an event which has no single instrument, e.g. those of
+	// the transactions of a trade
+	Exchanges []string
+	// Key identifies the entity the event updates, e.g. one order book;
+	// a newer event with the same key supersedes the older one.
+	// Empty for events which are never superseded, such as trades.
+	Key     string
+	Message proto.Message // decoded payload
+	Data    []byte        // payload as received from Kafka
+}
+
+// AllExchanges returns the exchanges the event carries data of
+func (ev *Event) AllExchanges() []string {
+	if ev.Instrument.Exchange == "" {
+		return ev.Exchanges
+	}
+	return append([]string{ev.Instrument.Exchange}, ev.Exchanges...)
+}
+
+// Key builds an event key from its parts
+func Key(parts ...string) string {
+	return strings.Join(parts, "/")
+}
+
+// ErrSnapshotRequired is returned by Resume when the frames the client
+// missed are no longer buffered or were published by an earlier run
+var ErrSnapshotRequired = errors.New("snapshot required")
+
+// Options of the hub
+type Options struct {
+	// ReplaySize is the number of frames per topic kept for Resume
+	ReplaySize int
+}
+
+// Hub fans out messages from one process-wide set of Kafka consumers
+// to the subscribers of each topic. Every subscription keeps its own
+// filters, see Filter. The latest frame of every event key is kept in
+// memory and sent as a snapshot on subscribe, and the last frames of every
+// topic are kept in a ring buffer so that reconnecting clients can resume.
+type Hub struct {
+	options Options
+	// epoch names this run of the hub; sequence numbers start again from 1
+	// after a restart, so they are only comparable within an epoch
+	epoch string
+
+	mu     sync.RWMutex
+	topics map[string]map[Subscriber][]Filter
+	latest map[string]map[string]*Frame
+	seq    map[string]uint64
+	replay map[string]*ring
+}
+
+func New(options Options) *Hub {
+	return &Hub{
+		options: options,
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		topics:  make(map[string]map[Subscriber][]Filter),
+		latest:  make(map[string]map[string]*Frame),
+		seq:     make(map[string]uint64),
+		replay:  make(map[string]*ring),
+	}
+}
+
+// Epoch returns the name of this run of the hub
+func (h *Hub) Epoch() string {
+	return h.epoch
+}
+
+// Topics returns the sorted list of topics known to the hub.
+func (h *Hub) Topics() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	topics := make([]string, 0, len(h.topics))
+	for topic := range h.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Subscribe adds the subscriber to every given topic with the given filters,
+// replacing the filters of an existing subscription, and sends it a snapshot
+// of each topic. Nothing is changed if at least one of the topics is unknown.
+func (h *Hub) Subscribe(s Subscriber, filters []Filter, topics ...string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, topic := range topics {
+		if _, ok := h.topics[topic]; !ok {
+			return fmt.Errorf("unknown topic: %s", topic)
+		}
+	}
+	for _, topic := range topics {
+		h.topics[topic][s] = filters
+		// Sent under the lock so that no event published after the snapshot
+		// was taken can reach the subscriber before it
+		s.SendSnapshot(topic, h.epoch, h.seq[topic], h.snapshot(topic, filters))
+	}
+	return nil
+}
+
+// Resume subscribes to the topic like Subscribe but instead of a snapshot
+// sends the frames published after lastSeq of the epoch. ErrSnapshotRequired
+// is returned and nothing is changed if the epoch is not the current one,
+// some of those frames are no longer buffered or they don't fit into the
+// queue of a Bounded subscriber.
+func (h *Hub) Resume(s Subscriber, filters []Filter, topic, epoch string, lastSeq uint64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.topics[topic]; !ok {
+		return fmt.Errorf("unknown topic: %s", topic)
+	}
+
+	current := h.seq[topic]
+	if epoch != h.epoch || lastSeq > current {
+		// The client saw a sequence of an earlier run of the server
+		return ErrSnapshotRequired
+	}
+	frames, ok := h.replay[topic].since(lastSeq)
+	if !ok || len(frames) < int(current-lastSeq) {
+		return ErrSnapshotRequired
+	}
+
+	var matching []*Frame
+	for _, frame := range frames {
+		if matchAny(filters, &frame.Event) {
+			matching = append(matching, frame)
+		}
+	}
+	if b, ok := s.(Bounded); ok && len(matching) > b.Room() {
+		return ErrSnapshotRequired
+	}
+
+	h.topics[topic][s] = filters
+	for _, frame := range matching {
+		s.Send(frame)
+	}
+	return nil
+}
+
+// snapshot returns the latest frames of the topic passing the filters
+func (h *Hub) snapshot(topic string, filters []Filter) []*Frame {
+	keys := make([]string, 0, len(h.latest[topic]))
+	for key := range h.latest[topic] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	frames := []*Frame{}
+	for _, key := range keys {
+		frame := h.latest[topic][key]
+		if matchAny(filters, &frame.Event) {
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+// Unsubscribe removes the subscriber from the given topics.
+func (h *Hub) Unsubscribe(s Subscriber, topics ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, topic := range topics {
+		delete(h.topics[topic], s)
+	}
+}
+
+// Unregister removes the subscriber from all topics.
+func (h *Hub) Unregister(s Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subscribers := range h.topics {
+		delete(subscribers, s)
+	}
+}
+
+// Subscriptions returns the sorted list of topics the subscriber listens to.
+func (h *Hub) Subscriptions(s Subscriber) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	topics := []string{}
+	for topic, subscribers := range h.topics {
+		if _, ok := subscribers[s]; ok {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Publish stamps the event with the next sequence number of its topic and
+// sends it to the subscribers whose filters match the event instrument. The
+// frame is remembered as the latest one of its key and in the replay buffer.
+func (h *Hub) Publish(ev Event) {
+	if ev.Message != nil {
+		ev.Type = string(ev.Message.ProtoReflect().Descriptor().Name())
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	payload, err := encodePayload(ev)
+	if err != nil {
+		log.Printf("Error encoding event of topic %s: %v", ev.Topic, err)
+		return
+	}
+
+	// Subscribers don't block in Send, so frames are delivered under the lock
+	// which keeps them ordered by sequence number
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ev.Seq = h.seq[ev.Topic] + 1
+	ev.Epoch = h.epoch
+	frame, err := newFrame(ev, payload)
+	if err != nil {
+		log.Printf("Error encoding event of topic %s: %v", ev.Topic, err)
+		return
+	}
+	h.seq[ev.Topic] = ev.Seq
+	if h.replay[ev.Topic] == nil {
+		h.replay[ev.Topic] = newRing(h.options.ReplaySize)
+	}
+	h.replay[ev.Topic].push(frame)
+
+	if ev.Key != "" {
+		if h.latest[ev.Topic] == nil {
+			h.latest[ev.Topic] = make(map[string]*Frame)
+		}
+		h.latest[ev.Topic][ev.Key] = frame
+	}
+	for s, filters := range h.topics[ev.Topic] {
+		if matchAny(filters, &ev) {
+			s.Send(frame)
+		}
+	}
+}
+
+// AddTopic makes the topic known to the hub so that clients can subscribe to it.
+func (h *Hub) AddTopic(topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.topics[topic]; !ok {
+		h.topics[topic] = make(map[Subscriber][]Filter)
+		h.replay[topic] = newRing(h.options.ReplaySize)
+		log.Printf("Hub topic added: %s", topic)
+	}
+}
@@ -0,0 +1,41 @@
+package hub
+
+// ring keeps the last frames of a topic for resuming clients
+type ring struct {
+	frames []*Frame
+	start  int
+	len    int
+}
+
+func newRing(size int) *ring {
+	return &ring{frames: make([]*Frame, size)}
+}
+
+func (r *ring) push(frame *Frame) {
+	if len(r.frames) == 0 {
+		return
+	}
+	if r.len < len(r.frames) {
+		r.frames[(r.start+r.len)%len(r.frames)] = frame
+		r.len++
+		return
+	}
+	r.frames[r.start] = frame
+	r.start = (r.start + 1) % len(r.frames)
+}
+
+// since returns the frames with a sequence number greater than seq and
+// reports false if some of them are no longer in the buffer
+func (r *ring) since(seq uint64) ([]*Frame, bool) {
+	var frames []*Frame
+	for i := 0; i < r.len; i++ {
+		frame := r.frames[(r.start+i)%len(r.frames)]
+		if frame.Seq > seq {
+			frames = append(frames, frame)
+		}
+	}
+	if len(frames) > 0 && frames[0].Seq != seq+1 {
+		return nil, false
+	}
+	return frames, true
+}
@@ -1,58 +1,155 @@
 package kafka
 
 import (
+	"context"
 	"log"
 	"sync"
+	"time"
 
+	"cryptobot_server/dlq"
 	"cryptobot_server/handlers"
 
 	"github.com/IBM/sarama"
 )
 
-func startKafkaConsumer() sarama.Consumer {
+// Connection settings, set with Configure before starting consumers
+var (
+	brokers  []string
+	username string
+	password string
+)
+
+// Configure sets the brokers and the SASL/PLAIN credentials; an empty
+// user disables SASL
+func Configure(kafkaBrokers []string, user, pass string) {
+	brokers = kafkaBrokers
+	username = user
+	password = pass
+}
+
+// How often the partition list of a topic is refreshed to pick up new partitions
+var partitionRefreshInterval = 30 * time.Second
+
+func newConfig() *sarama.Config {
 	config := sarama.NewConfig()
 	config.Consumer.Return.Errors = true
-	consumer, err := sarama.NewConsumer([]string{"localhost:19092"}, config)
+	if username != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		config.Net.SASL.User = username
+		config.Net.SASL.Password = password
+	}
+	return config
+}
+
+func startKafkaConsumer() (sarama.Client, sarama.Consumer) {
+	client, err := sarama.NewClient(brokers, newConfig())
+	if err != nil {
+		log.Fatal("Error creating Kafka client:", err)
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
 	if err != nil {
 		log.Fatal("Error creating Kafka consumer:", err)
 	}
-	return consumer
+	return client, consumer
 }
 
-func ConsumeMessages(topic string, messageChannel chan []byte, wg *sync.WaitGroup) {
+// ConsumeMessages reads every partition of the topic in its own goroutine and
+// passes the messages to the topic handler. Partitions added to the topic
+// at runtime are picked up on the next refresh. Messages the handler fails
+// on are put into deadLetters. It returns when ctx is done and every
+// partition has finished the message in progress.
+func ConsumeMessages(ctx context.Context, topic string, registry *handlers.Registry, deadLetters dlq.Queue, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	// Setup Kafka consumer to subscribe to the given topic
-	consumer := startKafkaConsumer()
+	client, consumer := startKafkaConsumer()
+	defer client.Close()
 	defer consumer.Close()
 
-	// Subscribe to the topic
-	partitions, err := consumer.Partitions(topic)
-	if err != nil {
-		log.Printf("Error fetching partitions for topic %s: %v\n", topic, err)
-		return
-	}
+	var (
+		mu         sync.Mutex
+		consuming  = make(map[int32]bool)
+		partitions sync.WaitGroup
+	)
 
-	// Consume messages from each partition
-	for _, partition := range partitions {
-		pc, err := consumer.ConsumePartition(topic, partition, sarama.OffsetNewest)
+	refresh := func() {
+		if err := client.RefreshMetadata(topic); err != nil {
+			log.Printf("Error refreshing metadata for topic %s: %v\n", topic, err)
+		}
+		ids, err := client.Partitions(topic)
 		if err != nil {
-			log.Printf("Error subscribing to partition %d for topic %s: %v\n", partition, topic, err)
+			log.Printf("Error fetching partitions for topic %s: %v\n", topic, err)
 			return
 		}
-		defer pc.Close()
 
-		// Loop to listen for new messages
-		for message := range pc.Messages() {
-			// Log raw Kafka message data (before processing)
-			log.Printf("Received raw Kafka message from topic %s: %s\n", topic, string(message.Value))
+		mu.Lock()
+		defer mu.Unlock()
+		for _, partition := range ids {
+			if consuming[partition] {
+				continue
+			}
+			pc, err := consumer.ConsumePartition(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				log.Printf("Error subscribing to partition %d for topic %s: %v\n", partition, topic, err)
+				continue
+			}
+			log.Printf("Consuming partition %d of topic %s\n", partition, topic)
+			consuming[partition] = true
 
-			// Pass the raw message and message channel to the appropriate handler
-			if handler, exists := handlers.TopicHandlers[topic]; exists {
-				handler(messageChannel, message.Value)
-			} else {
-				log.Printf("No handler defined for topic: %s", topic)
+			partitions.Add(1)
+			go func(partition int32) {
+				defer partitions.Done()
+				consumePartition(ctx, topic, pc, registry, deadLetters)
+
+				// The partition will be resubscribed on the next refresh
+				mu.Lock()
+				delete(consuming, partition)
+				mu.Unlock()
+			}(partition)
+		}
+	}
+
+	refresh()
+	ticker := time.NewTicker(partitionRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			refresh()
+		case <-ctx.Done():
+			partitions.Wait()
+			log.Printf("Stopped consuming topic %s\n", topic)
+			return
+		}
+	}
+}
+
+// consumePartition passes messages of one partition to the topic handler
+// until the partition consumer is closed or ctx is done
+func consumePartition(ctx context.Context, topic string, pc sarama.PartitionConsumer, registry *handlers.Registry, deadLetters dlq.Queue) {
+	defer pc.Close()
+
+	go func() {
+		for err := range pc.Errors() {
+			log.Printf("Error consuming topic %s: %v\n", topic, err)
+		}
+	}()
+
+	// Loop to listen for new messages
+	for {
+		select {
+		case message, ok := <-pc.Messages():
+			if !ok {
+				return
 			}
+			// Log raw Kafka message data (before processing)
+			log.Printf("Received raw Kafka message from topic %s partition %d: %s\n", topic, message.Partition, string(message.Value))
+
+			// Pass the raw message to the appropriate handler
+			handleMessage(ctx, message, registry, deadLetters)
+		case <-ctx.Done():
+			return
 		}
 	}
 }
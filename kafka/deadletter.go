@@ -0,0 +1,150 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"cryptobot_server/dlq"
+
+	"github.com/IBM/sarama"
+)
+
+// How long List waits for the next message of a dead letter partition
+var deadLetterReadTimeout = 10 * time.Second
+
+// DeadLetterTopic is a dlq.Queue backed by a Kafka topic. Entries are keyed
+// by their ID and removal writes a tombstone, so the topic may be compacted.
+type DeadLetterTopic struct {
+	topic    string
+	client   sarama.Client
+	producer sarama.SyncProducer
+}
+
+func NewDeadLetterTopic(topic string) (*DeadLetterTopic, error) {
+	config := newConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
+	}
+	return &DeadLetterTopic{topic: topic, client: client, producer: producer}, nil
+}
+
+func (d *DeadLetterTopic) Close() error {
+	if err := d.producer.Close(); err != nil {
+		return err
+	}
+	return d.client.Close()
+}
+
+func (d *DeadLetterTopic) Put(entry dlq.Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+	_, _, err = d.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: d.topic,
+		Key:   sarama.StringEncoder(entry.ID),
+		Value: sarama.ByteEncoder(data),
+	})
+	return err
+}
+
+func (d *DeadLetterTopic) Remove(id string) error {
+	if _, err := dlq.Get(d, id); err != nil {
+		return err
+	}
+	// A message with an empty value is a tombstone for the key
+	_, _, err := d.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: d.topic,
+		Key:   sarama.StringEncoder(id),
+	})
+	return err
+}
+
+// List reads the whole topic and returns the entries which have no tombstone
+func (d *DeadLetterTopic) List() ([]dlq.Entry, error) {
+	consumer, err := sarama.NewConsumerFromClient(d.client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	if err := d.client.RefreshMetadata(d.topic); err != nil {
+		return nil, fmt.Errorf("failed to refresh metadata for topic %s: %w", d.topic, err)
+	}
+	partitions, err := d.client.Partitions(d.topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch partitions for topic %s: %w", d.topic, err)
+	}
+
+	byID := make(map[string]dlq.Entry)
+	for _, partition := range partitions {
+		if err := d.readPartition(consumer, partition, byID); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]dlq.Entry, 0, len(byID))
+	for _, entry := range byID {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FailedAt.Before(entries[j].FailedAt)
+	})
+	return entries, nil
+}
+
+// readPartition folds the partition messages up to the current end into byID
+func (d *DeadLetterTopic) readPartition(consumer sarama.Consumer, partition int32, byID map[string]dlq.Entry) error {
+	oldest, err := d.client.GetOffset(d.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return fmt.Errorf("failed to get oldest offset of %s/%d: %w", d.topic, partition, err)
+	}
+	newest, err := d.client.GetOffset(d.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return fmt.Errorf("failed to get newest offset of %s/%d: %w", d.topic, partition, err)
+	}
+	if oldest >= newest {
+		return nil
+	}
+
+	pc, err := consumer.ConsumePartition(d.topic, partition, oldest)
+	if err != nil {
+		return fmt.Errorf("failed to consume %s/%d: %w", d.topic, partition, err)
+	}
+	defer pc.Close()
+
+	for {
+		select {
+		case message := <-pc.Messages():
+			id := string(message.Key)
+			if message.Value == nil {
+				delete(byID, id)
+			} else {
+				var entry dlq.Entry
+				if err := json.Unmarshal(message.Value, &entry); err != nil {
+					log.Printf("Skipping malformed dead letter at %s/%d offset %d: %v", d.topic, partition, message.Offset, err)
+				} else {
+					byID[id] = entry
+				}
+			}
+			if message.Offset >= newest-1 {
+				return nil
+			}
+		case err := <-pc.Errors():
+			return fmt.Errorf("failed to read %s/%d: %w", d.topic, partition, err)
+		case <-time.After(deadLetterReadTimeout):
+			return fmt.Errorf("timeout reading %s/%d", d.topic, partition)
+		}
+	}
+}
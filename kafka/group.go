@@ -0,0 +1,117 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"cryptobot_server/dlq"
+	"cryptobot_server/handlers"
+
+	"github.com/IBM/sarama"
+)
+
+// Pause before the group joins again after a failed Consume, doubled on
+// every failure in a row
+var (
+	groupRetryBackoff    = time.Second
+	groupMaxRetryBackoff = 30 * time.Second
+)
+
+func startKafkaConsumerGroup(groupID string) sarama.ConsumerGroup {
+	config := newConfig()
+	// A new group starts from the beginning so that nothing produced
+	// before the first start is lost; afterwards committed offsets are used
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config.Consumer.Offsets.AutoCommit.Enable = true
+	group, err := sarama.NewConsumerGroup(brokers, groupID, config)
+	if err != nil {
+		log.Fatal("Error creating Kafka consumer group:", err)
+	}
+	return group
+}
+
+// ConsumeGroup consumes the topics as a member of the consumer group. A
+// message offset is marked only after its handler has returned, so a
+// restarted server resumes where the group left off and several replicas
+// share the partitions. Messages the handler fails on are put into
+// deadLetters. When ctx is done the messages in progress are finished and
+// the marked offsets are committed before it returns.
+func ConsumeGroup(ctx context.Context, groupID string, topics []string, registry *handlers.Registry, deadLetters dlq.Queue, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	group := startKafkaConsumerGroup(groupID)
+	defer group.Close()
+
+	go func() {
+		for err := range group.Errors() {
+			log.Printf("Kafka consumer group %s error: %v\n", groupID, err)
+		}
+	}()
+
+	handler := &groupHandler{registry: registry, deadLetters: deadLetters}
+	backoff := groupRetryBackoff
+	for {
+		// Consume returns on every rebalance, so it has to be called in a loop
+		err := group.Consume(ctx, topics, handler)
+		if ctx.Err() != nil || errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			log.Printf("Stopped consuming topics %v in group %s\n", topics, groupID)
+			return
+		}
+		if err == nil {
+			backoff = groupRetryBackoff
+			continue
+		}
+
+		// Errors such as a failed metadata refresh or an unavailable
+		// coordinator are temporary, the group joins again after a pause
+		log.Printf("Error consuming topics %v in group %s, retrying in %s: %v\n", topics, groupID, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			log.Printf("Stopped consuming topics %v in group %s\n", topics, groupID)
+			return
+		}
+		backoff = min(backoff*2, groupMaxRetryBackoff)
+	}
+}
+
+// groupHandler implements sarama.ConsumerGroupHandler
+type groupHandler struct {
+	registry    *handlers.Registry
+	deadLetters dlq.Queue
+}
+
+func (g *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	log.Printf("Kafka consumer group session started, claims: %v\n", session.Claims())
+	return nil
+}
+
+func (g *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	log.Printf("Kafka consumer group session finished, claims: %v\n", session.Claims())
+	return nil
+}
+
+func (g *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			log.Printf("Received Kafka message from topic %s partition %d offset %d\n", message.Topic, message.Partition, message.Offset)
+
+			// A message interrupted by shutdown is not marked and is consumed
+			// again after restart. No later message of the claim may be marked
+			// then, since that would commit the offset past it.
+			if !handleMessage(session.Context(), message, g.registry, g.deadLetters) {
+				return nil
+			}
+			session.MarkMessage(message, "")
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
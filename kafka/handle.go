@@ -0,0 +1,94 @@
+package kafka
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cryptobot_server/dlq"
+	"cryptobot_server/handlers"
+
+	"github.com/IBM/sarama"
+)
+
+// Retry policy for handler errors which are not permanent and for writing
+// dead letters: the pause starts at retryBackoff and doubles up to
+// maxRetryBackoff
+var (
+	retryBackoff    = 500 * time.Millisecond
+	maxRetryBackoff = 30 * time.Second
+)
+
+func newMessage(message *sarama.ConsumerMessage) handlers.Message {
+	headers := make(map[string][]byte, len(message.Headers))
+	for _, header := range message.Headers {
+		headers[string(header.Key)] = header.Value
+	}
+	return handlers.Message{
+		Topic:     message.Topic,
+		Key:       message.Key,
+		Value:     message.Value,
+		Headers:   headers,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Timestamp: message.Timestamp,
+	}
+}
+
+// handleMessage passes a Kafka message to the topic handler. Messages whose
+// handler fails with a permanent error are routed to the dead-letter queue,
+// writing the dead letter is retried until the queue is available. Other
+// errors, e.g. Redis being down, are retried with backoff until the handler
+// succeeds, so an outage delays messages instead of dead-lettering them.
+// The handler runs to completion even if ctx is done, only the retries are
+// abandoned; then false is returned and the message is neither processed
+// nor dead-lettered, so it must not be marked.
+func handleMessage(ctx context.Context, message *sarama.ConsumerMessage, registry *handlers.Registry, deadLetters dlq.Queue) bool {
+	handler, exists := registry.Lookup(message.Topic)
+	if !exists {
+		log.Printf("No handler defined for topic: %s", message.Topic)
+		return true
+	}
+
+	msg := newMessage(message)
+	backoff := retryBackoff
+	var err error
+	for {
+		err = handler.Handle(context.WithoutCancel(ctx), msg)
+		if err == nil {
+			return true
+		}
+		log.Printf("Error handling message from topic %s partition %d offset %d: %v", message.Topic, message.Partition, message.Offset, err)
+		if handlers.IsPermanent(err) {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			log.Printf("Retries of message from topic %s partition %d offset %d abandoned on shutdown", message.Topic, message.Partition, message.Offset)
+			return false
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+
+	// The offset may only be committed once the message is in the
+	// dead-letter queue, so writing it is retried until it succeeds
+	entry := dlq.NewEntry(message.Topic, message.Partition, message.Offset, message.Timestamp, message.Value, err)
+	backoff = retryBackoff
+	for {
+		err := deadLetters.Put(entry)
+		if err == nil {
+			break
+		}
+		log.Printf("Error writing dead letter %s, retrying in %s: %v", entry.ID, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			log.Printf("Dead letter %s abandoned on shutdown, the message is not marked", entry.ID)
+			return false
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+	log.Printf("Message routed to dead-letter queue as %s", entry.ID)
+	return true
+}
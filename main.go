@@ -2,52 +2,296 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"cryptobot_server/auth"
+	"cryptobot_server/config"
+	"cryptobot_server/cors"
+	"cryptobot_server/dlq"
+	"cryptobot_server/handlers"
+	"cryptobot_server/hub"
+	"cryptobot_server/kafka"
 	"cryptobot_server/redis"
+	"cryptobot_server/sse"
+	"cryptobot_server/store"
+	"cryptobot_server/tlsconfig"
 	"cryptobot_server/websocket"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	goredis "github.com/redis/go-redis/v9"
 )
 
-var ctx = context.Background()
-
 func main() {
-	// Инициализация клиента Redis
-	rdb := redis.NewClient()
+	// Настройки: значения по умолчанию, файл, переменные окружения и флаги
+	cfg, printConfig, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if printConfig {
+		data, err := cfg.Redacted().YAML()
+		if err != nil {
+			log.Fatalf("Error printing config: %v", err)
+		}
+		fmt.Print(string(data))
+		return
+	}
+
+	// Контекст отменяется по SIGINT/SIGTERM, после чего начинается остановка
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Хранилище трейдов: Redis или память процесса для запуска без Redis
+	var trades store.TradeStore = store.NewMemoryStore()
+	var rdb *goredis.Client
+	if cfg.Storage == config.StorageRedis {
+		// Инициализация клиента Redis
+		rdb = redis.NewClient(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB)
+
+		// Проверка подключения
+		if err := rdb.Ping(ctx).Err(); err != nil {
+			log.Fatalf("Error connecting to Redis: %v", err)
+		}
+		redisTrades := redis.NewTradeStore(rdb)
+
+		// Данные старых версий переводятся в текущую схему и индексируются
+		migrated, err := redisTrades.Migrate(ctx)
+		if err != nil {
+			log.Fatalf("Error migrating trades in Redis: %v", err)
+		}
+		if migrated > 0 {
+			log.Printf("Migrated %d trades to the new Redis layout", migrated)
+		}
+		trades = redisTrades
+	}
+	log.Printf("Storing trades in %s", cfg.Storage)
+
+	// Очередь для сообщений, которые не удалось обработать
+	kafka.Configure(cfg.Kafka.Brokers, cfg.Kafka.Username, cfg.Kafka.Password)
+	var deadLetters dlq.Queue = dlq.NewFileQueue(cfg.DLQ.File)
+	var topicQueue *kafka.DeadLetterTopic
+	if cfg.DLQ.Topic != "" {
+		topicQueue, err = kafka.NewDeadLetterTopic(cfg.DLQ.Topic)
+		if err != nil {
+			log.Fatalf("Error creating dead-letter topic producer: %v", err)
+		}
+		deadLetters = topicQueue
+	}
+
+	// Один набор Kafka-консьюмеров на весь процесс, сообщения раздаются через хаб
+	h := hub.New(hub.Options{ReplaySize: cfg.Hub.ReplaySize})
+	registry := handlers.NewDefaultRegistry(h, trades)
+	// В хабе только топики, в которые публикуют обработчики
+	for _, topic := range registry.HubTopics() {
+		h.AddTopic(topic)
+	}
+
+	for _, topic := range append(cfg.Kafka.Topics, cfg.Kafka.GroupTopics...) {
+		if _, exists := registry.Lookup(topic); !exists {
+			log.Fatalf("No handler defined for configured topic: %s", topic)
+		}
+	}
 
-	// Проверка подключения
-	if err := rdb.Ping(ctx).Err(); err != nil {
-		log.Fatalf("Error connecting to Redis: %v", err)
+	var wg sync.WaitGroup
+	for _, topic := range cfg.Kafka.Topics {
+		wg.Add(1)
+		go kafka.ConsumeMessages(ctx, topic, registry, deadLetters, &wg)
 	}
 
-	// HTTP server for WebSocket
-	//http.HandleFunc("/ws", websocket.WSHandler)
+	// Топики, которые сохраняются в Redis, читаются через consumer group с коммитом оффсетов
+	if len(cfg.Kafka.GroupTopics) > 0 {
+		wg.Add(1)
+		go kafka.ConsumeGroup(ctx, cfg.Kafka.GroupID, cfg.Kafka.GroupTopics, registry, deadLetters, &wg)
+	}
+
+	// TLS для обоих серверов, сертификаты перечитываются при изменении файлов
+	var tlsConfig *tls.Config
+	if cfg.TLS.Enabled {
+		reloader, err := tlsconfig.NewReloader(tlsconfig.Options{
+			CertFile:       cfg.TLS.Cert,
+			KeyFile:        cfg.TLS.Key,
+			ClientCAFile:   cfg.TLS.ClientCA,
+			ReloadInterval: time.Duration(cfg.TLS.ReloadInterval),
+		})
+		if err != nil {
+			log.Fatalf("Error loading TLS files: %v", err)
+		}
+		go reloader.Watch(ctx.Done())
+		tlsConfig = reloader.Config()
+	}
 
-	// Запуск сервера
-	// log.Println("Starting server on 0.0.0.0:10999")
-	// if err := http.ListenAndServe("0.0.0.0:10999", nil); err != nil {
-	// 	log.Fatal("Error starting server:", err)
-	// }
+	// Создаем новый роутер Gin, CORS обрабатывается для всех маршрутов,
+	// включая preflight-запросы к маршрутам только с GET
+	corsOptions := cors.Options{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.CORS.AllowedMethods,
+		AllowedHeaders:   cfg.CORS.AllowedHeaders,
+		ExposedHeaders:   cfg.CORS.ExposedHeaders,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge:           time.Duration(cfg.CORS.MaxAge),
+	}
+	r := gin.New()
+	r.Use(gin.LoggerWithConfig(gin.LoggerConfig{Formatter: logFormatter}), gin.Recovery())
+	r.Use(cors.Middleware(corsOptions))
+
+	// Аутентификация по API-ключам и JWT, nil отключает проверки. Роли
+	// определяют, какие топики и биржи видит клиент
+	var authn *auth.Authenticator
+	if cfg.Auth.Enabled {
+		apiKeys := make([]auth.APIKey, 0, len(cfg.Auth.APIKeys))
+		for _, key := range cfg.Auth.APIKeys {
+			apiKeys = append(apiKeys, auth.APIKey{Name: key.Name, Key: key.Key, Scopes: key.Scopes, Roles: key.Roles})
+		}
+		roles := make(map[string]auth.Role, len(cfg.Auth.Roles))
+		for name, role := range cfg.Auth.Roles {
+			roles[name] = auth.Role{Scopes: role.Scopes, Topics: role.Topics, Exchanges: role.Exchanges}
+		}
+		authn, err = auth.New(auth.Options{
+			APIKeys:            apiKeys,
+			Roles:              roles,
+			HS256Secret:        cfg.Auth.JWT.HS256Secret,
+			RS256PublicKeyFile: cfg.Auth.JWT.RS256PublicKey,
+			Issuer:             cfg.Auth.JWT.Issuer,
+			Audience:           cfg.Auth.JWT.Audience,
+		})
+		if err != nil {
+			log.Fatalf("Error setting up authentication: %v", err)
+		}
+	}
 
-	// Создаем новый роутер Gin
-	r := gin.Default()
+	wsServer := websocket.NewServer(h, websocket.Options{
+		QueueSize:   cfg.WebSocket.QueueSize,
+		Policy:      cfg.WebSocket.SlowPolicy,
+		CheckOrigin: corsOptions.CheckOrigin,
+		Auth:        authn,
+	})
 
 	// Маршрут для получения списка транзакций по TradeID
-	r.GET("/transactions/:tradeID", redis.GetTransactions)
+	r.GET("/transactions/:tradeID", authn.Require(auth.ScopeTrades), store.TransactionsHandler(trades))
 
-	// HTTP server for WebSocket
-	go func() {
-		http.HandleFunc("/ws", websocket.WSHandler)
-		log.Println("Starting WebSocket server on 0.0.0.0:10999")
-		if err := http.ListenAndServe("0.0.0.0:10999", nil); err != nil {
-			log.Fatal("Error starting WebSocket server:", err)
+	// Список сохраненных трейдов постранично, с сортировкой и фильтрами
+	r.GET("/trades", authn.Require(auth.ScopeTrades), store.TradesHandler(trades))
+
+	// Просмотр и повторная обработка сообщений из dead-letter очереди
+	admin := r.Group("/admin", authn.Require(auth.ScopeAdmin))
+	admin.GET("/dlq", dlq.ListHandler(deadLetters))
+	admin.POST("/dlq/:id/replay", dlq.ReplayHandler(deadLetters, func(ctx context.Context, entry dlq.Entry) error {
+		handler, exists := registry.Lookup(entry.Topic)
+		if !exists {
+			return fmt.Errorf("no handler defined for topic: %s", entry.Topic)
+		}
+		return handler.Handle(ctx, handlers.Message{
+			Topic:     entry.Topic,
+			Value:     entry.Payload,
+			Partition: entry.Partition,
+			Offset:    entry.Offset,
+			Timestamp: entry.Timestamp,
+		})
+	}))
+
+	// Тот же поток данных через Server-Sent Events для клиентов без WebSocket
+	sseServer := sse.NewServer(h, cfg.SSE.QueueSize)
+	r.GET("/stream", authn.RequireStream(auth.ScopeStream), sseServer.Handler)
+
+	// WebSocket на том же роутере, что и REST, с общей цепочкой middleware
+	r.GET("/ws", authn.Handshake(auth.ScopeStream), gin.WrapH(wsServer))
+
+	// Подключенные WebSocket-клиенты и счетчики отброшенных сообщений
+	admin.GET("/ws/clients", wsServer.ClientsHandler)
+
+	// Основной адрес и дополнительные адреса с теми же маршрутами для обратной совместимости
+	servers := []*http.Server{{Addr: cfg.Listen, Handler: r, TLSConfig: tlsConfig}}
+	for _, addr := range cfg.ExtraListen {
+		servers = append(servers, &http.Server{Addr: addr, Handler: r, TLSConfig: tlsConfig})
+	}
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			log.Printf("Starting server on %s", srv.Addr)
+			if err := listenAndServe(srv); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("could not start server on %s: %v", srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down, waiting up to %s", time.Duration(cfg.ShutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+
+	// Стриминговые соединения закрываются сами, иначе Shutdown ждал бы их до таймаута
+	wsServer.Shutdown()
+	sseServer.Shutdown()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down server on %s: %v", srv.Addr, err)
 		}
+	}
+
+	// Консьюмеры дообрабатывают текущие сообщения и коммитят оффсеты
+	consumersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(consumersDone)
 	}()
+	select {
+	case <-consumersDone:
+	case <-shutdownCtx.Done():
+		log.Println("Timed out waiting for Kafka consumers")
+	}
+
+	if topicQueue != nil {
+		if err := topicQueue.Close(); err != nil {
+			log.Printf("Error closing dead-letter topic producer: %v", err)
+		}
+	}
+	if rdb != nil {
+		if err := rdb.Close(); err != nil {
+			log.Printf("Error closing Redis client: %v", err)
+		}
+	}
+	log.Println("Server stopped")
+}
+
+// logFormatter пишет строки в формате gin по умолчанию, но без токенов из
+// ?access_token=, с которыми подключаются /ws и /stream
+func logFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		auth.RedactQuery(param.Path),
+		param.ErrorMessage,
+	)
+}
 
-	// Запуск сервера
-	log.Println("Starting server on 0.0.0.0:8080")
-	if err := r.Run("0.0.0.0:8080"); err != nil {
-		log.Fatalf("could not start server: %v", err)
+// listenAndServe запускает сервер с TLS, если он настроен
+func listenAndServe(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		// Сертификаты берутся из TLSConfig, поэтому пути не передаются
+		return srv.ListenAndServeTLS("", "")
 	}
+	return srv.ListenAndServe()
 }
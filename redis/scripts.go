@@ -0,0 +1,240 @@
+package redis
+
+import "github.com/redis/go-redis/v9"
+
+// Keys of the trade indexes, see indexTradeLua
+const (
+	tradesByIDKey       = "trades:by_id"
+	tradesByIngestedKey = "trades:by_ingested"
+	tradesTagPrefix     = "trades:"
+	// schemaKey holds the schemaVersion the trades were last migrated to
+	schemaKey = "trades:schema"
+)
+
+// schemaVersion is the version of the layout written by the scripts; it is
+// raised whenever migrateTradeScript has to run over the stored trades again
+const schemaVersion = 2
+
+// indexTradeLua defines index_trade(id, set_key, by_id, by_ingested) which
+// brings the indexes of one trade up to date with its transactions:
+//
+//	trades:by_id                 sorted set of store.SortKey by ID, all scores 0
+//	trades:by_ingested           the same by ingestion time
+//	trades:<tag>:by_id           the same for the trades with the tag, e.g.
+//	trades:<tag>:by_ingested     trades:exchange:BYBIT:by_id,
+//	                             trades:trading_pair:BTCUSDT:by_ingested,
+//	                             trades:market_type:SPOT:by_id
+//	trade:<id>:tags              tags of the trade, to remove the stale ones
+//	trade:<id>:ingested          member of the trade in trades:by_ingested, written once
+//
+// Returns the number of entries added to the sorted sets. The keys are built
+// in the script, so it needs a single Redis instance.
+const indexTradeLua = `
+local function index_trade(id, set_key, by_id, by_ingested)
+	local added = 0
+	local ingested_key = 'trade:' .. id .. ':ingested'
+	if redis.call('SET', ingested_key, by_ingested, 'NX') then
+		added = added + redis.call('ZADD', 'trades:by_ingested', 0, by_ingested)
+	else
+		by_ingested = redis.call('GET', ingested_key)
+	end
+	added = added + redis.call('ZADD', 'trades:by_id', 0, by_id)
+
+	local tags_key = 'trade:' .. id .. ':tags'
+	local tags = {}
+	for _, key in ipairs(redis.call('ZRANGE', set_key, 0, -1)) do
+		local v = redis.call('HMGET', key, 'ExchangeId', 'TradingPair', 'MarketType')
+		if v[1] then tags['exchange:' .. v[1]] = true end
+		if v[2] then tags['trading_pair:' .. string.upper(v[2])] = true end
+		if v[3] then tags['market_type:' .. v[3]] = true end
+	end
+	for _, tag in ipairs(redis.call('SMEMBERS', tags_key)) do
+		if not tags[tag] then
+			redis.call('ZREM', 'trades:' .. tag .. ':by_id', by_id)
+			redis.call('ZREM', 'trades:' .. tag .. ':by_ingested', by_ingested)
+			redis.call('SREM', tags_key, tag)
+		end
+	end
+	for tag in pairs(tags) do
+		added = added + redis.call('ZADD', 'trades:' .. tag .. ':by_id', 0, by_id)
+		added = added + redis.call('ZADD', 'trades:' .. tag .. ':by_ingested', 0, by_ingested)
+		redis.call('SADD', tags_key, tag)
+	end
+	return added
+end
+`
+
+// saveTradeScript upserts the transactions of one trade atomically and
+// updates its indexes. A transaction is new if its key is not yet in the
+// sorted set of the trade, which replaces scanning the list of keys. A
+// changed transaction gets the next Version unless the stored one has a
+// later UpdatedAt; hashes written by older versions count as version 1
+// updated at 0.
+//
+//	KEYS[1]    trade:<id>:transactions, sorted set of transaction keys scored by index
+//	KEYS[2..]  trade:<id>:transaction:<index>
+//	ARGV[1]    updated-at, unix milliseconds
+//	ARGV[2]    trade ID
+//	ARGV[3]    sort key of the trade by ID
+//	ARGV[4]    sort key of the trade by ingestion time, used if the trade is new
+//	ARGV[5..]  index, TradingPair, ExchangeId, MarketType, TransactionAction per transaction
+//
+// Returns status, version and updated-at of every transaction, the status
+// values are those of store.Status.
+var saveTradeScript = redis.NewScript(indexTradeLua + `
+local updatedAt = tonumber(ARGV[1])
+local result = {}
+local changed = false
+for i = 2, #KEYS do
+	local a = 5 + (i - 2) * 5
+	local key = KEYS[i]
+	local status, version, at
+	if redis.call('ZADD', KEYS[1], 'NX', ARGV[a], key) == 1 then
+		status, version, at = 1, 1, updatedAt
+	else
+		local stored = redis.call('HMGET', key,
+			'TradingPair', 'ExchangeId', 'MarketType', 'TransactionAction', 'Version', 'UpdatedAt')
+		version = tonumber(stored[5]) or 1
+		at = tonumber(stored[6]) or 0
+		if updatedAt < at then
+			status = 3
+		elseif stored[1] == ARGV[a + 1] and stored[2] == ARGV[a + 2]
+			and stored[3] == ARGV[a + 3] and stored[4] == ARGV[a + 4] then
+			status = 0
+		else
+			status, version, at = 2, version + 1, updatedAt
+		end
+	end
+	if status == 1 or status == 2 then
+		redis.call('HSET', key,
+			'TradingPair', ARGV[a + 1],
+			'ExchangeId', ARGV[a + 2],
+			'MarketType', ARGV[a + 3],
+			'TransactionAction', ARGV[a + 4],
+			'Version', version,
+			'UpdatedAt', at)
+		changed = true
+	end
+	table.insert(result, status)
+	table.insert(result, version)
+	table.insert(result, at)
+end
+if changed then
+	index_trade(ARGV[2], KEYS[1], ARGV[3], ARGV[4])
+end
+return result
+`)
+
+// deleteTradeScript removes a trade with all its transactions and its
+// indexes. The transaction keys are read from the sorted set KEYS[1].
+//
+//	ARGV[1]  trade ID
+//	ARGV[2]  sort key of the trade by ID
+//
+// Returns 0 if there is no such trade.
+var deleteTradeScript = redis.NewScript(`
+local id = ARGV[1]
+local keys = redis.call('ZRANGE', KEYS[1], 0, -1)
+for _, key in ipairs(keys) do
+	redis.call('DEL', key)
+end
+local ingested_key = 'trade:' .. id .. ':ingested'
+local ingested = redis.call('GET', ingested_key)
+local tags_key = 'trade:' .. id .. ':tags'
+for _, tag in ipairs(redis.call('SMEMBERS', tags_key)) do
+	redis.call('ZREM', 'trades:' .. tag .. ':by_id', ARGV[2])
+	if ingested then
+		redis.call('ZREM', 'trades:' .. tag .. ':by_ingested', ingested)
+	end
+end
+redis.call('ZREM', 'trades:by_id', ARGV[2])
+if ingested then
+	redis.call('ZREM', 'trades:by_ingested', ingested)
+end
+redis.call('DEL', tags_key, ingested_key)
+return redis.call('DEL', KEYS[1])
+`)
+
+// migrateTradeScript converts the list of transaction keys KEYS[1] written
+// by older versions into the sorted set, the score is the index at the end
+// of each key, replaces the sets of trade IDs per tag of schema version 1
+// with the sorted sets of index_trade and indexes the trade.
+//
+//	ARGV  the same as ARGV[2..4] of saveTradeScript
+//
+// Returns 1 if anything was changed.
+var migrateTradeScript = redis.NewScript(indexTradeLua + `
+local changed = 0
+if redis.call('TYPE', KEYS[1]).ok == 'list' then
+	local keys = redis.call('LRANGE', KEYS[1], 0, -1)
+	redis.call('DEL', KEYS[1])
+	for _, key in ipairs(keys) do
+		redis.call('ZADD', KEYS[1], tonumber(string.match(key, ':(%d+)$')) or 0, key)
+	end
+	changed = 1
+end
+for _, tag in ipairs(redis.call('SMEMBERS', 'trade:' .. ARGV[1] .. ':tags')) do
+	if redis.call('DEL', 'trades:' .. tag) == 1 then
+		changed = 1
+	end
+end
+if index_trade(ARGV[1], KEYS[1], ARGV[2], ARGV[3]) > 0 then
+	changed = 1
+end
+return changed
+`)
+
+// listTaggedScript returns a page of the trades which are in all the sorted
+// sets of tags. It walks the smallest set from the bound in ranges of
+// ZRANGEBYLEX and keeps the entries found in the other sets, so a page
+// costs as much as the entries of the smallest set it skips.
+//
+//	KEYS     trades:<tag>:by_id or trades:<tag>:by_ingested sets of the filters
+//	ARGV[1]  bound of the page, "(" and a sort key; "-" or "+" for the first page
+//	ARGV[2]  "1" for descending order
+//	ARGV[3]  max number of entries
+//	ARGV[4]  number of entries read from the smallest set per range
+//
+// Returns the sort keys of the page in order.
+var listTaggedScript = redis.NewScript(`
+local smallest, size = 1, redis.call('ZCARD', KEYS[1])
+for i = 2, #KEYS do
+	local n = redis.call('ZCARD', KEYS[i])
+	if n < size then
+		smallest, size = i, n
+	end
+end
+local bound = ARGV[1]
+local desc = ARGV[2] == '1'
+local count = tonumber(ARGV[3])
+local batch = tonumber(ARGV[4])
+local page = {}
+while #page < count do
+	local entries
+	if desc then
+		entries = redis.call('ZREVRANGEBYLEX', KEYS[smallest], bound, '-', 'LIMIT', 0, batch)
+	else
+		entries = redis.call('ZRANGEBYLEX', KEYS[smallest], bound, '+', 'LIMIT', 0, batch)
+	end
+	for _, entry in ipairs(entries) do
+		local matches = true
+		for i = 1, #KEYS do
+			if i ~= smallest and not redis.call('ZSCORE', KEYS[i], entry) then
+				matches = false
+				break
+			end
+		end
+		if matches then
+			table.insert(page, entry)
+			if #page == count then
+				break
+			end
+		end
+	end
+	if #entries < batch then
+		break
+	end
+	bound = '(' .. entries[#entries]
+end
+return page
+`)
@@ -0,0 +1,388 @@
+package redis
+
+import (
+	"context"
+	"cryptobot_server/aot"
+	"cryptobot_server/store"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TradeStore implements store.TradeStore on Redis: a hash per transaction
+// under trade:<id>:transaction:<index>, with Version and UpdatedAt next to
+// the transaction fields, and the sorted set of these keys,
+// scored by index, under trade:<id>:transactions. Every trade is written
+// atomically together with its indexes by a Lua script, see scripts.go.
+type TradeStore struct {
+	client *redis.Client
+}
+
+var _ store.TradeStore = (*TradeStore)(nil)
+
+func NewTradeStore(client *redis.Client) *TradeStore {
+	return &TradeStore{client: client}
+}
+
+func transactionsKey(tradeID uint64) string {
+	return fmt.Sprintf("trade:%d:transactions", tradeID)
+}
+
+func transactionKey(tradeID uint64, index int) string {
+	return fmt.Sprintf("trade:%d:transaction:%d", tradeID, index)
+}
+
+// indexArgs returns the trade ID and its sort keys, the arguments of
+// index_trade in the scripts
+func indexArgs(tradeID uint64, ingestedAt time.Time) []interface{} {
+	return []interface{}{
+		tradeID,
+		store.SortKey(store.SortByID, tradeID, ingestedAt),
+		store.SortKey(store.SortByIngested, tradeID, ingestedAt),
+	}
+}
+
+// saveTradeArgs builds the keys and arguments of saveTradeScript
+func saveTradeArgs(trade *aot.Trade, updatedAt time.Time) ([]string, []interface{}) {
+	keys := make([]string, 0, len(trade.Transactions)+1)
+	args := make([]interface{}, 0, len(trade.Transactions)*5+4)
+	keys = append(keys, transactionsKey(trade.Id))
+	args = append(args, updatedAt.UnixMilli())
+	args = append(args, indexArgs(trade.Id, updatedAt)...)
+	for i, transaction := range trade.Transactions {
+		keys = append(keys, transactionKey(trade.Id, i))
+		args = append(args,
+			i,
+			transaction.TradingPair,
+			transaction.ExchangeId.String(),
+			transaction.MarketType.String(),
+			transaction.TransactionAction.String(),
+		)
+	}
+	return keys, args
+}
+
+func (s *TradeStore) SaveTrade(ctx context.Context, trade *aot.Trade, updatedAt time.Time) ([]store.Change, error) {
+	return s.SaveTrades(ctx, []*aot.Trade{trade}, updatedAt)
+}
+
+// SaveTrades writes the trades in one round trip; each trade is written
+// atomically but the batch as a whole is not
+func (s *TradeStore) SaveTrades(ctx context.Context, trades []*aot.Trade, updatedAt time.Time) ([]store.Change, error) {
+	changes, err := s.saveTrades(ctx, trades, updatedAt, saveTradeScript.EvalSha)
+	if err != nil && redis.HasErrorPrefix(err, "NOSCRIPT") {
+		// The script cache is empty, e.g. after a restart of Redis. Saving
+		// the same transactions twice changes nothing, so the whole batch
+		// is sent again with the script body.
+		changes, err = s.saveTrades(ctx, trades, updatedAt, saveTradeScript.Eval)
+	}
+	return changes, err
+}
+
+func (s *TradeStore) saveTrades(ctx context.Context, trades []*aot.Trade, updatedAt time.Time,
+	eval func(context.Context, redis.Scripter, []string, ...interface{}) *redis.Cmd) ([]store.Change, error) {
+	cmds := make([]*redis.Cmd, len(trades))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, trade := range trades {
+			keys, args := saveTradeArgs(trade, updatedAt)
+			cmds[i] = eval(ctx, pipe, keys, args...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save trades to Redis: %w", err)
+	}
+
+	var changes []store.Change
+	for i, cmd := range cmds {
+		values, err := cmd.Int64Slice()
+		if err != nil || len(values) != 3*len(trades[i].Transactions) {
+			return nil, fmt.Errorf("unexpected reply saving trade %d: %v", trades[i].Id, cmd.Val())
+		}
+		for j := 0; j < len(values); j += 3 {
+			changes = append(changes, store.Change{
+				TradeID:   trades[i].Id,
+				Index:     j / 3,
+				Status:    store.Status(values[j]),
+				Version:   uint64(values[j+1]),
+				UpdatedAt: time.UnixMilli(values[j+2]),
+			})
+		}
+	}
+	return changes, nil
+}
+
+func (s *TradeStore) GetTrade(ctx context.Context, tradeID uint64) (*aot.Trade, error) {
+	trades, err := s.getTrades(ctx, []uint64{tradeID})
+	if err != nil {
+		return nil, err
+	}
+	if trades[0] == nil {
+		return nil, store.ErrNotFound
+	}
+	return trades[0], nil
+}
+
+// getTrades reads the trades in two round trips: the sorted sets of
+// transaction keys of all trades, then all transaction hashes. Trades
+// without transactions are nil.
+func (s *TradeStore) getTrades(ctx context.Context, tradeIDs []uint64) ([]*aot.Trade, error) {
+	keyCmds := make([]*redis.StringSliceCmd, len(tradeIDs))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range tradeIDs {
+			keyCmds[i] = pipe.ZRange(ctx, transactionsKey(id), 0, -1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction keys: %w", err)
+	}
+
+	// Считываем все хэши транзакций за один запрос
+	cmds := make([][]*redis.MapStringStringCmd, len(tradeIDs))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, cmd := range keyCmds {
+			for _, key := range cmd.Val() {
+				cmds[i] = append(cmds[i], pipe.HGetAll(ctx, key))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions: %w", err)
+	}
+
+	trades := make([]*aot.Trade, len(tradeIDs))
+	for i, id := range tradeIDs {
+		if len(cmds[i]) == 0 {
+			continue
+		}
+		trade := &aot.Trade{Id: id}
+		for j, cmd := range cmds[i] {
+			transaction, err := parseTransaction(cmd.Val())
+			if err != nil {
+				return nil, fmt.Errorf("invalid transaction %s: %w", keyCmds[i].Val()[j], err)
+			}
+			trade.Transactions = append(trade.Transactions, transaction)
+		}
+		trades[i] = trade
+	}
+	return trades, nil
+}
+
+// parseTransaction converts the fields of a transaction hash
+func parseTransaction(data map[string]string) (*aot.Transaction, error) {
+	exchangeId, err := NewExchangeId(data["ExchangeId"])
+	if err != nil {
+		return nil, err
+	}
+	marketType, err := NewMarketType(data["MarketType"])
+	if err != nil {
+		return nil, err
+	}
+	transactionAction, err := NewTransactionAction(data["TransactionAction"])
+	if err != nil {
+		return nil, err
+	}
+	return &aot.Transaction{
+		TradingPair:       data["TradingPair"],
+		ExchangeId:        exchangeId,
+		MarketType:        marketType,
+		TransactionAction: transactionAction,
+	}, nil
+}
+
+// tradeIDs returns the IDs of all stored trades in ascending order
+func (s *TradeStore) tradeIDs(ctx context.Context) ([]uint64, error) {
+	// Идентификаторы трейдов берутся из ключей множеств транзакций
+	var ids []uint64
+	iter := s.client.Scan(ctx, 0, "trade:*:transactions", 0).Iterator()
+	for iter.Next(ctx) {
+		id, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(iter.Val(), "trade:"), ":transactions"), 10, 64)
+		if err != nil {
+			log.Printf("Skipping unexpected key %s", iter.Val())
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan trades: %w", err)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// listTaggedBatch is the number of entries listTaggedScript reads per range
+const listTaggedBatch = 256
+
+func (s *TradeStore) ListTrades(ctx context.Context, q store.Query) (store.Page, error) {
+	after, err := q.After()
+	if err != nil {
+		return store.Page{}, err
+	}
+	// У каждого тега свои отсортированные множества в обоих порядках
+	suffix := ":by_id"
+	if q.Sort == store.SortByIngested {
+		suffix = ":by_ingested"
+	}
+	var filters []string
+	if q.Exchange != "" {
+		filters = append(filters, tradesTagPrefix+"exchange:"+strings.ToUpper(q.Exchange)+suffix)
+	}
+	if q.TradingPair != "" {
+		filters = append(filters, tradesTagPrefix+"trading_pair:"+strings.ToUpper(q.TradingPair)+suffix)
+	}
+	if q.MarketType != "" {
+		filters = append(filters, tradesTagPrefix+"market_type:"+strings.ToUpper(q.MarketType)+suffix)
+	}
+	index := tradesByIDKey
+	if q.Sort == store.SortByIngested {
+		index = tradesByIngestedKey
+	}
+	if len(filters) == 1 {
+		index = filters[0]
+	}
+
+	// Читается на одну запись больше страницы: по ней видно, есть ли
+	// следующая страница
+	count := int64(q.Limit + 1)
+	var keys []string
+	if len(filters) > 1 {
+		// Для нескольких фильтров обходится самое маленькое множество
+		bound := "-"
+		if q.Desc {
+			bound = "+"
+		}
+		if after != "" {
+			bound = "(" + after
+		}
+		desc := "0"
+		if q.Desc {
+			desc = "1"
+		}
+		keys, err = listTaggedScript.Run(ctx, s.client, filters, bound, desc, count, listTaggedBatch).StringSlice()
+		if err != nil {
+			return store.Page{}, fmt.Errorf("failed to read tagged trades: %w", err)
+		}
+	} else {
+		by := &redis.ZRangeBy{Min: "-", Max: "+", Count: count}
+		if q.Desc {
+			if after != "" {
+				by.Max = "(" + after
+			}
+			keys, err = s.client.ZRevRangeByLex(ctx, index, by).Result()
+		} else {
+			if after != "" {
+				by.Min = "(" + after
+			}
+			keys, err = s.client.ZRangeByLex(ctx, index, by).Result()
+		}
+		if err != nil {
+			return store.Page{}, fmt.Errorf("failed to read trade index: %w", err)
+		}
+	}
+
+	var page store.Page
+	if len(keys) > q.Limit {
+		keys = keys[:q.Limit]
+		page.NextCursor = q.NextCursor(keys[len(keys)-1])
+	}
+	ids := make([]uint64, len(keys))
+	for i, key := range keys {
+		if ids[i], err = indexedID(key); err != nil {
+			return store.Page{}, err
+		}
+	}
+	trades, err := s.getTrades(ctx, ids)
+	if err != nil {
+		return store.Page{}, err
+	}
+	page.Trades = make([]*aot.Trade, 0, len(trades))
+	for _, trade := range trades {
+		// nil, если трейд удален после чтения индекса
+		if trade != nil {
+			page.Trades = append(page.Trades, trade)
+		}
+	}
+	return page, nil
+}
+
+// indexedID returns the trade ID at the end of a store.SortKey
+func indexedID(key string) (uint64, error) {
+	if len(key) < 20 {
+		return 0, fmt.Errorf("invalid trade index entry %q", key)
+	}
+	id, err := strconv.ParseUint(key[len(key)-20:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid trade index entry %q: %w", key, err)
+	}
+	return id, nil
+}
+
+func (s *TradeStore) DeleteTrade(ctx context.Context, tradeID uint64) error {
+	deleted, err := deleteTradeScript.Run(ctx, s.client, []string{transactionsKey(tradeID)},
+		tradeID, store.SortKey(store.SortByID, tradeID, time.Time{})).Int()
+	if err != nil {
+		return fmt.Errorf("failed to delete trade %d: %w", tradeID, err)
+	}
+	if deleted == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// migrateBatchSize is the number of trades migrated per round trip
+const migrateBatchSize = 500
+
+// Migrate converts the lists of transaction keys written by older versions
+// into sorted sets and indexes the trades stored before the indexes
+// existed, their ingestion time is the time of the migration. A completed
+// migration records schemaVersion, later calls return at once. Returns the
+// number of changed trades.
+func (s *TradeStore) Migrate(ctx context.Context) (int, error) {
+	version, err := s.client.Get(ctx, schemaKey).Int()
+	if err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version >= schemaVersion {
+		return 0, nil
+	}
+
+	ids, err := s.tradeIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := migrateTradeScript.Load(ctx, s.client).Err(); err != nil {
+		return 0, fmt.Errorf("failed to load migration script: %w", err)
+	}
+
+	now := time.Now()
+	migrated := 0
+	for start := 0; start < len(ids); start += migrateBatchSize {
+		batch := ids[start:min(start+migrateBatchSize, len(ids))]
+		cmds := make([]*redis.Cmd, len(batch))
+		_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, id := range batch {
+				cmds[i] = migrateTradeScript.EvalSha(ctx, pipe, []string{transactionsKey(id)}, indexArgs(id, now)...)
+			}
+			return nil
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("failed to migrate trades from %d: %w", batch[0], err)
+		}
+		for _, cmd := range cmds {
+			changed, _ := cmd.Int()
+			migrated += changed
+		}
+	}
+
+	if err := s.client.Set(ctx, schemaKey, schemaVersion, 0).Err(); err != nil {
+		return migrated, fmt.Errorf("failed to record schema version: %w", err)
+	}
+	return migrated, nil
+}
@@ -0,0 +1,241 @@
+package redis
+
+import (
+	"context"
+	"cryptobot_server/aot"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The benchmarks compare the writes and reads of the first version, a
+// command per transaction field and a scan of the list of transaction keys
+// before every push, with the Lua scripts and pipelines of TradeStore. They
+// need a Redis server at CRYPTOBOT_REDIS_ADDR (localhost:6379 by default)
+// and are skipped if it is unreachable:
+//
+//	go test -run '^$' -bench . ./redis
+//
+// Trades are written to database benchDB with IDs from benchFirstID and
+// deleted afterwards.
+const (
+	benchDB           = 15
+	benchFirstID      = uint64(1 << 40)
+	benchTransactions = 4
+	benchBatchSize    = 100
+)
+
+func benchClient(b *testing.B) *redis.Client {
+	addr := os.Getenv("CRYPTOBOT_REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	client := NewClient(addr, os.Getenv("CRYPTOBOT_REDIS_PASSWORD"), benchDB)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		b.Skipf("Redis at %s is unreachable: %v", addr, err)
+	}
+
+	// NewExchangeId logs every call
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		client.Close()
+	})
+	return client
+}
+
+func benchTrade(id uint64) *aot.Trade {
+	trade := &aot.Trade{Id: id}
+	for i := 0; i < benchTransactions; i++ {
+		trade.Transactions = append(trade.Transactions, &aot.Transaction{
+			TradingPair:       fmt.Sprintf("PAIR%dUSDT", i),
+			ExchangeId:        aot.ExchangeId_BYBIT,
+			MarketType:        aot.MarketType_FUTURES,
+			TransactionAction: aot.TransactionAction_BUY,
+		})
+	}
+	return trade
+}
+
+// legacySaveTrade is the write path of the first version: HSETNX per
+// field, then LRANGE over the whole list before each LPUSH
+func legacySaveTrade(ctx context.Context, client *redis.Client, trade *aot.Trade) error {
+	listKey := transactionsKey(trade.Id)
+	for i, transaction := range trade.Transactions {
+		key := transactionKey(trade.Id, i)
+		fields := map[string]interface{}{
+			"TradingPair":       transaction.TradingPair,
+			"ExchangeId":        transaction.ExchangeId.String(),
+			"MarketType":        transaction.MarketType.String(),
+			"TransactionAction": transaction.TransactionAction.String(),
+		}
+		for field, value := range fields {
+			if err := client.HSetNX(ctx, key, field, value).Err(); err != nil {
+				return err
+			}
+		}
+
+		items, err := client.LRange(ctx, listKey, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(items, key) {
+			if err := client.LPush(ctx, listKey, key).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// legacyGetTrade is the read path of the first version: LRANGE, then one
+// HGETALL round trip per transaction
+func legacyGetTrade(ctx context.Context, client *redis.Client, id uint64) (*aot.Trade, error) {
+	keys, err := client.LRange(ctx, transactionsKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	trade := &aot.Trade{Id: id}
+	for _, key := range keys {
+		data, err := client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		transaction, err := parseTransaction(data)
+		if err != nil {
+			return nil, err
+		}
+		trade.Transactions = append(trade.Transactions, transaction)
+	}
+	return trade, nil
+}
+
+// deleteLegacy removes the trades written by legacySaveTrade
+func deleteLegacy(b *testing.B, client *redis.Client, first uint64, n int) {
+	ctx := context.Background()
+	for id := first; id < first+uint64(n); id++ {
+		keys := []string{transactionsKey(id)}
+		for i := 0; i < benchTransactions; i++ {
+			keys = append(keys, transactionKey(id, i))
+		}
+		if err := client.Del(ctx, keys...).Err(); err != nil {
+			b.Fatalf("deleting trade %d: %v", id, err)
+		}
+	}
+}
+
+// deleteTrades removes the trades written by TradeStore with their indexes
+func deleteTrades(b *testing.B, s *TradeStore, first uint64, n int) {
+	ctx := context.Background()
+	for id := first; id < first+uint64(n); id++ {
+		if err := s.DeleteTrade(ctx, id); err != nil {
+			b.Fatalf("deleting trade %d: %v", id, err)
+		}
+	}
+}
+
+func BenchmarkSaveTrade(b *testing.B) {
+	client := benchClient(b)
+	s := NewTradeStore(client)
+	ctx := context.Background()
+
+	b.Run("legacy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := legacySaveTrade(ctx, client, benchTrade(benchFirstID+uint64(i))); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		deleteLegacy(b, client, benchFirstID, b.N)
+	})
+	b.Run("lua", func(b *testing.B) {
+		now := time.Now()
+		for i := 0; i < b.N; i++ {
+			if _, err := s.SaveTrade(ctx, benchTrade(benchFirstID+uint64(i)), now); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		deleteTrades(b, s, benchFirstID, b.N)
+	})
+}
+
+// BenchmarkSaveTrades writes a trade_dictionary message of benchBatchSize
+// trades per operation
+func BenchmarkSaveTrades(b *testing.B) {
+	client := benchClient(b)
+	s := NewTradeStore(client)
+	ctx := context.Background()
+
+	batch := func(i int) []*aot.Trade {
+		trades := make([]*aot.Trade, benchBatchSize)
+		for j := range trades {
+			trades[j] = benchTrade(benchFirstID + uint64(i*benchBatchSize+j))
+		}
+		return trades
+	}
+
+	b.Run("legacy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, trade := range batch(i) {
+				if err := legacySaveTrade(ctx, client, trade); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.StopTimer()
+		deleteLegacy(b, client, benchFirstID, b.N*benchBatchSize)
+	})
+	b.Run("lua", func(b *testing.B) {
+		now := time.Now()
+		for i := 0; i < b.N; i++ {
+			if _, err := s.SaveTrades(ctx, batch(i), now); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		deleteTrades(b, s, benchFirstID, b.N*benchBatchSize)
+	})
+}
+
+func BenchmarkGetTrade(b *testing.B) {
+	client := benchClient(b)
+	s := NewTradeStore(client)
+	ctx := context.Background()
+
+	b.Run("legacy", func(b *testing.B) {
+		if err := legacySaveTrade(ctx, client, benchTrade(benchFirstID)); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := legacyGetTrade(ctx, client, benchFirstID); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		deleteLegacy(b, client, benchFirstID, 1)
+	})
+	b.Run("pipeline", func(b *testing.B) {
+		if _, err := s.SaveTrade(ctx, benchTrade(benchFirstID), time.Now()); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := s.GetTrade(ctx, benchFirstID); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		deleteTrades(b, s, benchFirstID, 1)
+	})
+}
@@ -0,0 +1,288 @@
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cryptobot_server/auth"
+	"cryptobot_server/hub"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How often a comment line is written to keep proxies from closing an idle stream
+var keepAlivePeriod = 15 * time.Second
+
+// Server streams hub topics as Server-Sent Events for clients which can't
+// use WebSocket. Events carry the same JSON envelopes as /ws.
+type Server struct {
+	hub          *hub.Hub
+	queueSize    int
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+func NewServer(h *hub.Hub, queueSize int) *Server {
+	return &Server{hub: h, queueSize: queueSize, shutdown: make(chan struct{})}
+}
+
+// Shutdown ends all open streams; http.Server.Shutdown would otherwise
+// wait for them until its deadline
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+}
+
+// message is an event queued for writing
+type message struct {
+	event string
+	topic string
+	seq   uint64
+	data  []byte
+}
+
+// stream is one SSE connection, it implements hub.Subscriber. A stream whose
+// queue overflows is closed: the client reconnects with Last-Event-ID and
+// gets the missed messages, which is cheaper than buffering them here.
+type stream struct {
+	// principal limits the topics and exchanges of the stream, nil if auth
+	// is disabled
+	principal    *auth.Principal
+	messages     chan message
+	overflow     chan struct{}
+	overflowOnce sync.Once
+}
+
+func (st *stream) push(m message) {
+	// Nothing may follow a dropped event, the client resumes from before it
+	select {
+	case <-st.overflow:
+		return
+	default:
+	}
+	select {
+	case st.messages <- m:
+	default:
+		st.overflowOnce.Do(func() { close(st.overflow) })
+	}
+}
+
+// Room implements hub.Bounded: a gap larger than the queue is not replayed,
+// the topic gets a snapshot instead
+func (st *stream) Room() int {
+	return cap(st.messages) - len(st.messages)
+}
+
+// receives reports whether the principal may get the frame, a filter
+// without an exchange still carries messages of every exchange, including
+// trades whose exchanges are those of their transactions
+func (st *stream) receives(frame *hub.Frame) bool {
+	return st.principal == nil || st.principal.AllowsMessage(frame.Topic, frame.AllExchanges())
+}
+
+func (st *stream) Send(frame *hub.Frame) {
+	if !st.receives(frame) {
+		return
+	}
+	st.push(message{event: frame.Topic, topic: frame.Topic, seq: frame.Seq, data: frame.JSON})
+}
+
+func (st *stream) SendSnapshot(topic, epoch string, seq uint64, frames []*hub.Frame) {
+	if st.principal != nil {
+		allowed := make([]*hub.Frame, 0, len(frames))
+		for _, frame := range frames {
+			if st.receives(frame) {
+				allowed = append(allowed, frame)
+			}
+		}
+		frames = allowed
+	}
+	data, err := hub.SnapshotJSON(topic, epoch, seq, frames)
+	if err != nil {
+		log.Printf("Error encoding snapshot of topic %s: %v", topic, err)
+		return
+	}
+	st.push(message{event: "snapshot", topic: topic, seq: seq, data: data})
+}
+
+// Handler serves GET /stream?topics=orderbook,pnl&exchange=BYBIT&trading_pair=BTCUSDT.
+// Without topics all topics the client may receive are streamed; exchange, market_type and
+// trading_pair form a filter like the one of a /ws subscription.
+//
+// The id of every event names the epoch of the server and lists the last
+// sequence number of each topic, e.g. "m2k1x9c0@orderbook:5310,pnl:17". A
+// client reconnecting with it in Last-Event-ID (or ?last_event_id=) gets the
+// missed messages, or a fresh snapshot of the topics which can no longer be
+// resumed, e.g. all of them after a restart of the server.
+func (s *Server) Handler(c *gin.Context) {
+	// Without ?topics= the client gets every topic its scopes allow
+	var topics []string
+	if param := c.Query("topics"); param != "" {
+		topics = strings.Split(param, ",")
+		for _, topic := range topics {
+			if !auth.Allowed(c.Request.Context(), auth.TopicScope(topic)) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to subscribe to topic " + topic})
+				return
+			}
+		}
+	} else {
+		for _, topic := range s.hub.Topics() {
+			if auth.Allowed(c.Request.Context(), auth.TopicScope(topic)) {
+				topics = append(topics, topic)
+			}
+		}
+		if len(topics) == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to subscribe to any topic"})
+			return
+		}
+	}
+
+	var filters []hub.Filter
+	filter := hub.Filter{
+		Exchange:    c.Query("exchange"),
+		MarketType:  c.Query("market_type"),
+		TradingPair: c.Query("trading_pair"),
+	}
+	if filter != (hub.Filter{}) {
+		filters = append(filters, filter)
+	}
+	principal, _ := auth.FromContext(c.Request.Context())
+	if principal != nil && !principal.AllowsExchange(filter.Exchange) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to subscribe to exchange " + filter.Exchange})
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	epoch, lastSeq, err := parseEventID(lastEventID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if epoch != s.hub.Epoch() {
+		// Sequence numbers of another run of the server mean nothing here
+		lastSeq = make(map[string]uint64)
+	}
+
+	st := &stream{
+		principal: principal,
+		messages:  make(chan message, s.queueSize),
+		overflow:  make(chan struct{}),
+	}
+	defer s.hub.Unregister(st)
+
+	// Resume what can be resumed, subscribe the rest with a snapshot
+	var fresh []string
+	for _, topic := range topics {
+		seq, ok := lastSeq[topic]
+		if !ok {
+			fresh = append(fresh, topic)
+			continue
+		}
+		err := s.hub.Resume(st, filters, topic, epoch, seq)
+		if errors.Is(err, hub.ErrSnapshotRequired) {
+			fresh = append(fresh, topic)
+			continue
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if len(fresh) > 0 {
+		if err := s.hub.Subscribe(st, filters, fresh...); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	write := func(m message) bool {
+		lastSeq[m.topic] = m.seq
+		if _, err := fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", formatEventID(s.hub.Epoch(), lastSeq), m.event, m.data); err != nil {
+			log.Println("Error writing SSE event:", err)
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	}
+
+	ticker := time.NewTicker(keepAlivePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-st.messages:
+			if !write(m) {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				log.Println("Error writing SSE keep-alive:", err)
+				return
+			}
+			c.Writer.Flush()
+		case <-st.overflow:
+			// The queued events precede the dropped one, writing them lets the
+			// client resume from the last of them instead of starting over
+			for len(st.messages) > 0 {
+				if !write(<-st.messages) {
+					return
+				}
+			}
+			log.Println("SSE client is too slow, closing stream")
+			return
+		case <-c.Request.Context().Done():
+			return
+		case <-s.shutdown:
+			return
+		}
+	}
+}
+
+// parseEventID parses "epoch@topic:seq,topic:seq"
+func parseEventID(id string) (string, map[string]uint64, error) {
+	lastSeq := make(map[string]uint64)
+	if id == "" {
+		return "", lastSeq, nil
+	}
+	// Ids of older versions have no epoch, their topics get a snapshot
+	epoch, list, ok := strings.Cut(id, "@")
+	if !ok {
+		epoch, list = "", id
+	}
+	for _, part := range strings.Split(list, ",") {
+		topic, seq, ok := strings.Cut(part, ":")
+		if !ok {
+			return "", nil, fmt.Errorf("invalid event id: %q", id)
+		}
+		n, err := strconv.ParseUint(seq, 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid event id: %q", id)
+		}
+		lastSeq[topic] = n
+	}
+	return epoch, lastSeq, nil
+}
+
+func formatEventID(epoch string, lastSeq map[string]uint64) string {
+	parts := make([]string, 0, len(lastSeq))
+	for topic, seq := range lastSeq {
+		parts = append(parts, topic+":"+strconv.FormatUint(seq, 10))
+	}
+	sort.Strings(parts)
+	return epoch + "@" + strings.Join(parts, ",")
+}
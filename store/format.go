@@ -0,0 +1,219 @@
+package store
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cryptobot_server/aot"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Response formats of the trade endpoints, chosen with ?format= or Accept
+const (
+	formatProtobuf = "protobuf"
+	formatJSON     = "json"
+	formatCSV      = "csv"
+)
+
+var contentTypes = map[string]string{
+	formatProtobuf: "application/x-protobuf",
+	formatJSON:     "application/json; charset=utf-8",
+	formatCSV:      "text/csv; charset=utf-8",
+}
+
+// mediaFormats maps media types of the Accept header to formats; wildcards
+// mean the default format of the endpoint
+var mediaFormats = map[string]string{
+	"application/x-protobuf":          formatProtobuf,
+	"application/protobuf":            formatProtobuf,
+	"application/vnd.google.protobuf": formatProtobuf,
+	"application/json":                formatJSON,
+	"text/csv":                        formatCSV,
+	"text/*":                          formatCSV,
+	"application/*":                   "",
+	"*/*":                             "",
+}
+
+// csvHeader is the first row of CSV responses, one row per transaction follows
+var csvHeader = []string{"trade_id", "index", "exchange_id", "market_type", "trading_pair", "transaction_action"}
+
+// negotiate picks the response format: ?format= wins over Accept, no
+// preference gives fallback. If nothing acceptable is supported, fallback
+// is returned with false.
+func negotiate(c *gin.Context, fallback string) (string, bool) {
+	if format := c.Query("format"); format != "" {
+		if format == "protojson" {
+			format = formatJSON
+		}
+		_, ok := contentTypes[format]
+		if !ok {
+			return fallback, false
+		}
+		return format, true
+	}
+
+	accept := c.GetHeader("Accept")
+	if accept == "" {
+		return fallback, true
+	}
+	type candidate struct {
+		format string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		format, ok := mediaFormats[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		if format == "" {
+			format = fallback
+		}
+		candidates = append(candidates, candidate{format: format, q: q})
+	}
+	if len(candidates) == 0 {
+		return fallback, false
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].format, true
+}
+
+// writeError sends {"error": msg} in the format, as a google.protobuf.Struct
+// for protobuf and as a one-column table for CSV
+func writeError(c *gin.Context, status int, format string, msg string) {
+	var data []byte
+	var err error
+	switch format {
+	case formatProtobuf:
+		var body *structpb.Struct
+		body, err = structpb.NewStruct(map[string]interface{}{"error": msg})
+		if err == nil {
+			data, err = proto.Marshal(body)
+		}
+	case formatCSV:
+		data, err = encodeCSV([][]string{{"error"}, {msg}})
+	default:
+		data, err = json.Marshal(gin.H{"error": msg})
+	}
+	if err != nil {
+		log.Printf("Error encoding error response: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(status, contentTypes[format], data)
+}
+
+// writeTrade sends one trade: aot.Trade for protobuf, its protojson for JSON
+// and a row per transaction for CSV
+func writeTrade(c *gin.Context, format string, trade *aot.Trade) {
+	var data []byte
+	var err error
+	switch format {
+	case formatProtobuf:
+		data, err = proto.Marshal(trade)
+	case formatCSV:
+		data, err = encodeCSV(tradeRows(trade))
+	default:
+		var encoded []byte
+		if encoded, err = jsonOptions.Marshal(trade); err == nil {
+			// protojson output is unstable on purpose, compacting makes it stable
+			data, err = json.Marshal(json.RawMessage(encoded))
+		}
+	}
+	if err != nil {
+		log.Printf("Error marshalling trade %d: %v", trade.Id, err)
+		writeError(c, http.StatusInternalServerError, format, "Failed to serialize data")
+		return
+	}
+	c.Data(http.StatusOK, contentTypes[format], data)
+}
+
+// writePage sends a page of trades: aot.Trades for protobuf, tradesPage for
+// JSON and a row per transaction for CSV. The next cursor is also sent in
+// the X-Next-Cursor header since the protobuf and CSV bodies can't hold it;
+// aot.Trades is a map, so protobuf clients have to order the trades again.
+func writePage(c *gin.Context, format string, page Page) {
+	var data []byte
+	var err error
+	switch format {
+	case formatProtobuf:
+		message := &aot.Trades{Trades: make(map[uint64]*aot.Trade, len(page.Trades))}
+		for _, trade := range page.Trades {
+			message.Trades[trade.Id] = trade
+		}
+		data, err = proto.MarshalOptions{Deterministic: true}.Marshal(message)
+	case formatCSV:
+		data, err = encodeCSV(tradeRows(page.Trades...))
+	default:
+		body := tradesPage{Trades: make([]json.RawMessage, 0, len(page.Trades)), NextCursor: page.NextCursor}
+		for _, trade := range page.Trades {
+			var encoded []byte
+			if encoded, err = jsonOptions.Marshal(trade); err != nil {
+				break
+			}
+			body.Trades = append(body.Trades, encoded)
+		}
+		if err == nil {
+			data, err = json.Marshal(body)
+		}
+	}
+	if err != nil {
+		log.Printf("Error marshalling trades: %v", err)
+		writeError(c, http.StatusInternalServerError, format, "Failed to serialize data")
+		return
+	}
+	if page.NextCursor != "" {
+		c.Header("X-Next-Cursor", page.NextCursor)
+	}
+	c.Data(http.StatusOK, contentTypes[format], data)
+}
+
+// tradeRows returns the CSV header and a row per transaction of the trades
+func tradeRows(trades ...*aot.Trade) [][]string {
+	rows := [][]string{csvHeader}
+	for _, trade := range trades {
+		for i, transaction := range trade.Transactions {
+			rows = append(rows, []string{
+				strconv.FormatUint(trade.Id, 10),
+				strconv.Itoa(i),
+				transaction.ExchangeId.String(),
+				transaction.MarketType.String(),
+				transaction.TradingPair,
+				transaction.TransactionAction.String(),
+			})
+		}
+	}
+	return rows
+}
+
+func encodeCSV(rows [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
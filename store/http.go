@@ -0,0 +1,137 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cryptobot_server/aot"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// DefaultLimit is the page size of GET /trades without ?limit=
+const DefaultLimit = 100
+
+var jsonOptions = protojson.MarshalOptions{
+	UseProtoNames:   true,
+	EmitUnpopulated: true,
+}
+
+// tradesPage is the JSON body of GET /trades
+type tradesPage struct {
+	Trades     []json.RawMessage `json:"trades"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// TradesHandler lists stored trades page by page: GET /trades
+//
+//	?sort=id|ingested   order, id by default
+//	?order=asc|desc     asc by default
+//	?exchange=BYBIT&trading_pair=BTCUSDT&market_type=SPOT
+//	?limit=100          page size, up to MaxLimit
+//	?cursor=...         next_cursor of the previous page
+//
+// The response is JSON unless another format is negotiated, see negotiate.
+func TradesHandler(s TradeStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format, ok := negotiate(c, formatJSON)
+		if !ok {
+			writeError(c, http.StatusNotAcceptable, format, "supported formats: protobuf, json, csv")
+			return
+		}
+
+		q := Query{
+			Sort:        c.DefaultQuery("sort", SortByID),
+			Exchange:    c.Query("exchange"),
+			TradingPair: c.Query("trading_pair"),
+			MarketType:  c.Query("market_type"),
+			Cursor:      c.Query("cursor"),
+			Limit:       DefaultLimit,
+		}
+		switch order := c.DefaultQuery("order", "asc"); order {
+		case "asc":
+		case "desc":
+			q.Desc = true
+		default:
+			writeError(c, http.StatusBadRequest, format, "order must be asc or desc: "+order)
+			return
+		}
+		if limit := c.Query("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil {
+				writeError(c, http.StatusBadRequest, format, "invalid limit: "+limit)
+				return
+			}
+			q.Limit = n
+		}
+		if q.Exchange != "" {
+			if _, ok := aot.ExchangeId_value[strings.ToUpper(q.Exchange)]; !ok {
+				writeError(c, http.StatusBadRequest, format, "unknown exchange: "+q.Exchange)
+				return
+			}
+		}
+		if q.MarketType != "" {
+			if _, ok := aot.MarketType_value[strings.ToUpper(q.MarketType)]; !ok {
+				writeError(c, http.StatusBadRequest, format, "unknown market type: "+q.MarketType)
+				return
+			}
+		}
+		if err := q.Validate(); err != nil {
+			writeError(c, http.StatusBadRequest, format, err.Error())
+			return
+		}
+
+		page, err := s.ListTrades(c.Request.Context(), q)
+		if err != nil {
+			log.Printf("Error listing trades: %v", err)
+			writeError(c, http.StatusInternalServerError, format, err.Error())
+			return
+		}
+		writePage(c, format, page)
+	}
+}
+
+// TransactionsHandler returns the trade with its transactions:
+// GET /transactions/:tradeID. The response is protobuf unless another
+// format is negotiated, see negotiate.
+func TransactionsHandler(s TradeStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Логируем входящий запрос
+		log.Printf("Incoming request for TradeID: %s", c.Param("tradeID"))
+
+		// Формат ответа по ?format= или Accept, по умолчанию protobuf, как и раньше
+		format, ok := negotiate(c, formatProtobuf)
+		if !ok {
+			writeError(c, http.StatusNotAcceptable, format, "supported formats: protobuf, json, csv")
+			return
+		}
+
+		// Получаем TradeID из параметров запроса
+		tradeIDParam := c.Param("tradeID")
+		tradeID, err := strconv.ParseUint(tradeIDParam, 10, 64)
+		if err != nil {
+			log.Printf("Error parsing TradeID '%s': %v", tradeIDParam, err)
+			writeError(c, http.StatusBadRequest, format, "Invalid TradeID")
+			return
+		}
+
+		// Получаем транзакции из хранилища, неизвестный трейд отдается пустым, как и раньше
+		trade, err := s.GetTrade(c.Request.Context(), tradeID)
+		if errors.Is(err, ErrNotFound) {
+			trade, err = &aot.Trade{Id: tradeID}, nil
+		}
+		if err != nil {
+			log.Printf("Error fetching transactions for TradeID %d: %v", tradeID, err)
+			writeError(c, http.StatusInternalServerError, format, err.Error())
+			return
+		}
+		log.Printf("Trade %d: %d transactions", tradeID, len(trade.Transactions))
+
+		writeTrade(c, format, trade)
+	}
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cryptobot_server/aot"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// MemoryStore keeps trades in the process memory, for tests and for running
+// without Redis
+type MemoryStore struct {
+	mu     sync.RWMutex
+	trades map[uint64]*memoryTrade
+}
+
+type memoryTrade struct {
+	transactions map[int]*memoryTransaction
+	ingestedAt   time.Time
+}
+
+type memoryTransaction struct {
+	transaction *aot.Transaction
+	version     uint64
+	updatedAt   time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{trades: make(map[uint64]*memoryTrade)}
+}
+
+func (s *MemoryStore) SaveTrade(ctx context.Context, trade *aot.Trade, updatedAt time.Time) ([]Change, error) {
+	return s.SaveTrades(ctx, []*aot.Trade{trade}, updatedAt)
+}
+
+func (s *MemoryStore) SaveTrades(ctx context.Context, trades []*aot.Trade, updatedAt time.Time) ([]Change, error) {
+	// Same precision as in Redis
+	updatedAt = time.UnixMilli(updatedAt.UnixMilli())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changes []Change
+	for _, trade := range trades {
+		changes = append(changes, s.saveTrade(trade, updatedAt)...)
+	}
+	return changes, nil
+}
+
+func (s *MemoryStore) saveTrade(trade *aot.Trade, updatedAt time.Time) []Change {
+	stored, ok := s.trades[trade.Id]
+	if !ok {
+		stored = &memoryTrade{
+			transactions: make(map[int]*memoryTransaction),
+			ingestedAt:   updatedAt,
+		}
+		s.trades[trade.Id] = stored
+	}
+
+	changes := make([]Change, 0, len(trade.Transactions))
+	for i, transaction := range trade.Transactions {
+		change := Change{TradeID: trade.Id, Index: i}
+		current, exists := stored.transactions[i]
+		switch {
+		case !exists:
+			current = &memoryTransaction{
+				transaction: proto.Clone(transaction).(*aot.Transaction),
+				version:     1,
+				updatedAt:   updatedAt,
+			}
+			stored.transactions[i] = current
+			change.Status = Created
+		case updatedAt.Before(current.updatedAt):
+			change.Status = Stale
+		case proto.Equal(transaction, current.transaction):
+			change.Status = Unchanged
+		default:
+			current.transaction = proto.Clone(transaction).(*aot.Transaction)
+			current.version++
+			current.updatedAt = updatedAt
+			change.Status = Updated
+		}
+		change.Version = current.version
+		change.UpdatedAt = current.updatedAt
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func (s *MemoryStore) GetTrade(ctx context.Context, id uint64) (*aot.Trade, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stored, ok := s.trades[id]
+	if !ok || len(stored.transactions) == 0 {
+		return nil, ErrNotFound
+	}
+	return stored.trade(id), nil
+}
+
+func (s *MemoryStore) ListTrades(ctx context.Context, q Query) (Page, error) {
+	after, err := q.After()
+	if err != nil {
+		return Page{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	type entry struct {
+		key string
+		id  uint64
+	}
+	var entries []entry
+	for id, stored := range s.trades {
+		if len(stored.transactions) == 0 || !stored.matches(q) {
+			continue
+		}
+		key := SortKey(q.Sort, id, stored.ingestedAt)
+		if after != "" && (!q.Desc && key <= after || q.Desc && key >= after) {
+			continue
+		}
+		entries = append(entries, entry{key: key, id: id})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if q.Desc {
+			return entries[i].key > entries[j].key
+		}
+		return entries[i].key < entries[j].key
+	})
+
+	var page Page
+	if len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+		page.NextCursor = q.NextCursor(entries[len(entries)-1].key)
+	}
+	page.Trades = make([]*aot.Trade, 0, len(entries))
+	for _, e := range entries {
+		page.Trades = append(page.Trades, s.trades[e.id].trade(e.id))
+	}
+	return page, nil
+}
+
+// matches reports whether the trade passes the filters of the query
+func (t *memoryTrade) matches(q Query) bool {
+	exchange, pair, marketType := q.Exchange == "", q.TradingPair == "", q.MarketType == ""
+	for _, stored := range t.transactions {
+		exchange = exchange || strings.EqualFold(stored.transaction.ExchangeId.String(), q.Exchange)
+		pair = pair || strings.EqualFold(stored.transaction.TradingPair, q.TradingPair)
+		marketType = marketType || strings.EqualFold(stored.transaction.MarketType.String(), q.MarketType)
+	}
+	return exchange && pair && marketType
+}
+
+func (s *MemoryStore) DeleteTrade(ctx context.Context, id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.trades[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.trades, id)
+	return nil
+}
+
+// trade returns a copy, callers may modify it without holding the lock
+func (t *memoryTrade) trade(id uint64) *aot.Trade {
+	indexes := make([]int, 0, len(t.transactions))
+	for i := range t.transactions {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	trade := &aot.Trade{Id: id}
+	for _, i := range indexes {
+		trade.Transactions = append(trade.Transactions, proto.Clone(t.transactions[i].transaction).(*aot.Transaction))
+	}
+	return trade
+}
@@ -0,0 +1,309 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cryptobot_server/aot"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func testTrade(id uint64, pairs ...string) *aot.Trade {
+	trade := &aot.Trade{Id: id}
+	for _, pair := range pairs {
+		trade.Transactions = append(trade.Transactions, &aot.Transaction{
+			TradingPair:       pair,
+			ExchangeId:        aot.ExchangeId_BYBIT,
+			MarketType:        aot.MarketType_SPOT,
+			TransactionAction: aot.TransactionAction_BUY,
+		})
+	}
+	return trade
+}
+
+func statuses(changes []Change) []Status {
+	result := make([]Status, len(changes))
+	for i, change := range changes {
+		result[i] = change.Status
+	}
+	return result
+}
+
+func equalStatuses(got []Status, want ...Status) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryStoreSaveAndGet(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	trade := testTrade(7, "BTCUSDT", "ETHUSDT")
+
+	changes, err := s.SaveTrade(ctx, trade, time.UnixMilli(1000))
+	if err != nil {
+		t.Fatalf("SaveTrade: %v", err)
+	}
+	if got := statuses(changes); !equalStatuses(got, Created, Created) {
+		t.Fatalf("statuses = %v, want created twice", got)
+	}
+	for _, change := range changes {
+		if change.TradeID != 7 || change.Version != 1 || !change.UpdatedAt.Equal(time.UnixMilli(1000)) {
+			t.Errorf("change = %+v, want trade 7 version 1 at 1000ms", change)
+		}
+	}
+
+	got, err := s.GetTrade(ctx, 7)
+	if err != nil {
+		t.Fatalf("GetTrade: %v", err)
+	}
+	if !proto.Equal(got, trade) {
+		t.Fatalf("GetTrade = %v, want %v", got, trade)
+	}
+
+	// The returned trade is a copy
+	got.Transactions[0].TradingPair = "XRPUSDT"
+	again, _ := s.GetTrade(ctx, 7)
+	if again.Transactions[0].TradingPair != "BTCUSDT" {
+		t.Errorf("stored trade changed through the returned copy: %v", again)
+	}
+
+	if _, err := s.GetTrade(ctx, 8); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetTrade of an unknown trade: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryStoreUpdate(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	if _, err := s.SaveTrade(ctx, testTrade(1, "BTCUSDT", "ETHUSDT"), time.UnixMilli(1000)); err != nil {
+		t.Fatalf("SaveTrade: %v", err)
+	}
+
+	changes, err := s.SaveTrade(ctx, testTrade(1, "BTCUSDT", "SOLUSDT"), time.UnixMilli(2000))
+	if err != nil {
+		t.Fatalf("SaveTrade: %v", err)
+	}
+	if got := statuses(changes); !equalStatuses(got, Unchanged, Updated) {
+		t.Fatalf("statuses = %v, want unchanged, updated", got)
+	}
+	if changes[0].Version != 1 || changes[1].Version != 2 {
+		t.Errorf("versions = %d, %d, want 1, 2", changes[0].Version, changes[1].Version)
+	}
+	if !changes[1].UpdatedAt.Equal(time.UnixMilli(2000)) {
+		t.Errorf("updated at = %v, want 2000ms", changes[1].UpdatedAt)
+	}
+
+	got, _ := s.GetTrade(ctx, 1)
+	if got.Transactions[1].TradingPair != "SOLUSDT" {
+		t.Errorf("transaction 1 = %v, want SOLUSDT", got.Transactions[1])
+	}
+}
+
+func TestMemoryStoreStaleUpdate(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	if _, err := s.SaveTrade(ctx, testTrade(1, "BTCUSDT"), time.UnixMilli(2000)); err != nil {
+		t.Fatalf("SaveTrade: %v", err)
+	}
+
+	changes, err := s.SaveTrade(ctx, testTrade(1, "ETHUSDT"), time.UnixMilli(1000))
+	if err != nil {
+		t.Fatalf("SaveTrade: %v", err)
+	}
+	if got := statuses(changes); !equalStatuses(got, Stale) {
+		t.Fatalf("statuses = %v, want stale", got)
+	}
+	// A stale change reports the stored version
+	if changes[0].Version != 1 || !changes[0].UpdatedAt.Equal(time.UnixMilli(2000)) {
+		t.Errorf("change = %+v, want version 1 at 2000ms", changes[0])
+	}
+
+	got, _ := s.GetTrade(ctx, 1)
+	if got.Transactions[0].TradingPair != "BTCUSDT" {
+		t.Errorf("stale message overwrote the trade: %v", got)
+	}
+
+	// A message as old as the stored one is not stale
+	changes, _ = s.SaveTrade(ctx, testTrade(1, "ETHUSDT"), time.UnixMilli(2000))
+	if got := statuses(changes); !equalStatuses(got, Updated) {
+		t.Errorf("statuses = %v, want updated", got)
+	}
+}
+
+func TestMemoryStoreDelete(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	if _, err := s.SaveTrade(ctx, testTrade(1, "BTCUSDT"), time.UnixMilli(1000)); err != nil {
+		t.Fatalf("SaveTrade: %v", err)
+	}
+
+	if err := s.DeleteTrade(ctx, 1); err != nil {
+		t.Fatalf("DeleteTrade: %v", err)
+	}
+	if _, err := s.GetTrade(ctx, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetTrade after delete: err = %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteTrade(ctx, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second DeleteTrade: err = %v, want ErrNotFound", err)
+	}
+
+	// A deleted trade is created again from scratch
+	changes, _ := s.SaveTrade(ctx, testTrade(1, "BTCUSDT"), time.UnixMilli(500))
+	if got := statuses(changes); !equalStatuses(got, Created) || changes[0].Version != 1 {
+		t.Errorf("changes = %+v, want created with version 1", changes)
+	}
+}
+
+// listAll pages through ListTrades and returns the IDs of all pages
+func listAll(t *testing.T, s *MemoryStore, q Query) []uint64 {
+	t.Helper()
+	var ids []uint64
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("query %+v: too many pages", q)
+		}
+		page, err := s.ListTrades(context.Background(), q)
+		if err != nil {
+			t.Fatalf("ListTrades(%+v): %v", q, err)
+		}
+		if len(page.Trades) > q.Limit {
+			t.Fatalf("query %+v: page of %d trades", q, len(page.Trades))
+		}
+		for _, trade := range page.Trades {
+			ids = append(ids, trade.Id)
+		}
+		if page.NextCursor == "" {
+			return ids
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func equalIDs(got []uint64, want ...uint64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryStoreListTrades(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	// Trades are ingested in a different order than their IDs
+	for i, id := range []uint64{3, 1, 5, 2, 4} {
+		if _, err := s.SaveTrade(ctx, testTrade(id, "BTCUSDT"), time.UnixMilli(int64(1000*(i+1)))); err != nil {
+			t.Fatalf("SaveTrade: %v", err)
+		}
+	}
+	// Trade 2 is also on MEXC futures, trade 4 only there
+	mexc := &aot.Transaction{
+		TradingPair: "ETHUSDT",
+		ExchangeId:  aot.ExchangeId_MEXC,
+		MarketType:  aot.MarketType_FUTURES,
+	}
+	two := testTrade(2, "BTCUSDT")
+	two.Transactions = append(two.Transactions, mexc)
+	four := &aot.Trade{Id: 4, Transactions: []*aot.Transaction{mexc}}
+	if _, err := s.SaveTrades(ctx, []*aot.Trade{two, four}, time.UnixMilli(10000)); err != nil {
+		t.Fatalf("SaveTrades: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []uint64
+	}{
+		{"by id", Query{Sort: SortByID}, []uint64{1, 2, 3, 4, 5}},
+		{"by id desc", Query{Sort: SortByID, Desc: true}, []uint64{5, 4, 3, 2, 1}},
+		// An update keeps the ingestion time of the first save
+		{"by ingested", Query{Sort: SortByIngested}, []uint64{3, 1, 5, 2, 4}},
+		{"by ingested desc", Query{Sort: SortByIngested, Desc: true}, []uint64{4, 2, 5, 1, 3}},
+		{"exchange", Query{Sort: SortByID, Exchange: "mexc"}, []uint64{2, 4}},
+		{"trading pair", Query{Sort: SortByID, TradingPair: "btcusdt"}, []uint64{1, 2, 3, 5}},
+		{"all filters", Query{Sort: SortByIngested, Desc: true, Exchange: "MEXC", TradingPair: "ETHUSDT", MarketType: "futures"}, []uint64{4, 2}},
+		// Filters must hold for some transaction each, not for the same one
+		{"filters on different transactions", Query{Sort: SortByID, Exchange: "BYBIT", MarketType: "FUTURES"}, []uint64{2}},
+		{"no match", Query{Sort: SortByID, Exchange: "BINANCE"}, nil},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{1, 2, MaxLimit} {
+			q := tt.query
+			q.Limit = limit
+			if got := listAll(t, s, q); !equalIDs(got, tt.want...) {
+				t.Errorf("%s, limit %d: trades = %v, want %v", tt.name, limit, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestMemoryStoreListTradesCursor(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	for id := uint64(1); id <= 3; id++ {
+		if _, err := s.SaveTrade(ctx, testTrade(id, "BTCUSDT"), time.UnixMilli(1000)); err != nil {
+			t.Fatalf("SaveTrade: %v", err)
+		}
+	}
+
+	q := Query{Sort: SortByID, Exchange: "bybit", Limit: 1}
+	page, err := s.ListTrades(ctx, q)
+	if err != nil || page.NextCursor == "" {
+		t.Fatalf("first page: %v, %v; want a next cursor", page, err)
+	}
+
+	// The filters are compared case-insensitively
+	same := q
+	same.Exchange = "BYBIT"
+	same.Cursor = page.NextCursor
+	second, err := s.ListTrades(ctx, same)
+	if err != nil || len(second.Trades) != 1 || second.Trades[0].Id != 2 {
+		t.Errorf("second page: %v, %v; want trade 2", second, err)
+	}
+
+	// A deleted trade doesn't invalidate the cursor
+	if err := s.DeleteTrade(ctx, 1); err != nil {
+		t.Fatalf("DeleteTrade: %v", err)
+	}
+	if _, err := s.ListTrades(ctx, same); err != nil {
+		t.Errorf("cursor after a delete: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(q *Query)
+	}{
+		{"order", func(q *Query) { q.Desc = true }},
+		{"sort", func(q *Query) { q.Sort = SortByIngested }},
+		{"exchange", func(q *Query) { q.Exchange = "MEXC" }},
+		{"dropped filter", func(q *Query) { q.Exchange = "" }},
+		{"added filter", func(q *Query) { q.TradingPair = "BTCUSDT" }},
+		{"market type", func(q *Query) { q.MarketType = "SPOT" }},
+		{"garbage", func(q *Query) { q.Cursor = "not a cursor" }},
+	}
+	for _, tt := range tests {
+		other := q
+		other.Cursor = page.NextCursor
+		tt.change(&other)
+		if _, err := s.ListTrades(ctx, other); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor with a different %s: err = %v, want ErrInvalidCursor", tt.name, err)
+		}
+		if err := other.Validate(); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Validate with a different %s: err = %v, want ErrInvalidCursor", tt.name, err)
+		}
+	}
+}
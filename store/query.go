@@ -0,0 +1,105 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cryptobot_server/aot"
+)
+
+// Orders of ListTrades
+const (
+	SortByID       = "id"
+	SortByIngested = "ingested"
+)
+
+// MaxLimit bounds the number of trades in one page
+const MaxLimit = 1000
+
+// ErrInvalidCursor is returned for a cursor which wasn't issued for the
+// requested order and filters
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Query selects a page of trades. A trade passes the filters if it has a
+// transaction on the exchange, one with the trading pair and one with the
+// market type, compared case-insensitively; empty filters pass every trade.
+type Query struct {
+	Sort        string
+	Desc        bool
+	Exchange    string
+	TradingPair string
+	MarketType  string
+	// Cursor is Page.NextCursor of the previous page, empty for the first one
+	Cursor string
+	Limit  int
+}
+
+// Page is a result of ListTrades
+type Page struct {
+	Trades []*aot.Trade
+	// NextCursor fetches the following page, empty if this one is the last
+	NextCursor string
+}
+
+// Validate checks the order, the limit and the cursor
+func (q Query) Validate() error {
+	if q.Sort != SortByID && q.Sort != SortByIngested {
+		return fmt.Errorf("sort must be %s or %s: %q", SortByID, SortByIngested, q.Sort)
+	}
+	if q.Limit < 1 || q.Limit > MaxLimit {
+		return fmt.Errorf("limit must be between 1 and %d: %d", MaxLimit, q.Limit)
+	}
+	if _, err := q.After(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// After returns the sort key the page starts after, empty for the first page
+func (q Query) After() (string, error) {
+	if q.Cursor == "" {
+		return "", nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	key, ok := strings.CutPrefix(string(data), q.scope()+"|")
+	if !ok || key == "" || strings.Contains(key, "|") {
+		return "", ErrInvalidCursor
+	}
+	return key, nil
+}
+
+// NextCursor returns the cursor of the page starting after the sort key
+func (q Query) NextCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(q.scope() + "|" + key))
+}
+
+// scope identifies the listing a cursor belongs to: the order and the
+// filters, which are case-insensitive
+func (q Query) scope() string {
+	order := "asc"
+	if q.Desc {
+		order = "desc"
+	}
+	return strings.Join([]string{
+		q.Sort,
+		order,
+		strings.ToUpper(q.Exchange),
+		strings.ToUpper(q.TradingPair),
+		strings.ToUpper(q.MarketType),
+	}, "|")
+}
+
+// SortKey returns the position of a trade in the order; keys of the same
+// order compare as strings
+func SortKey(sort string, id uint64, ingestedAt time.Time) string {
+	if sort == SortByIngested {
+		return fmt.Sprintf("%013d:%020d", ingestedAt.UnixMilli(), id)
+	}
+	return fmt.Sprintf("%020d", id)
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cryptobot_server/aot"
+)
+
+// ErrNotFound is returned when there is no trade with the requested ID
+var ErrNotFound = errors.New("trade not found")
+
+// Status tells what saving did with a transaction
+type Status int
+
+const (
+	Unchanged Status = iota
+	Created
+	Updated
+	// Stale means the update was rejected, the stored transaction is newer
+	Stale
+)
+
+func (s Status) String() string {
+	switch s {
+	case Unchanged:
+		return "unchanged"
+	case Created:
+		return "created"
+	case Updated:
+		return "updated"
+	case Stale:
+		return "stale"
+	}
+	return "unknown"
+}
+
+// Change is the outcome of saving one transaction. Version and UpdatedAt
+// are those of the stored transaction after the save.
+type Change struct {
+	TradeID   uint64
+	Index     int
+	Status    Status
+	Version   uint64
+	UpdatedAt time.Time
+}
+
+// TradeStore keeps the trades received from the trade_dictionary topic
+type TradeStore interface {
+	// SaveTrade merges the transactions of the trade into the stored one;
+	// transactions are identified by their index. A transaction which
+	// differs from the stored one replaces it and gets the next version,
+	// unless updatedAt is older than that of the stored one. One change is
+	// returned per transaction.
+	SaveTrade(ctx context.Context, trade *aot.Trade, updatedAt time.Time) ([]Change, error)
+	// SaveTrades saves a batch of trades like SaveTrade
+	SaveTrades(ctx context.Context, trades []*aot.Trade, updatedAt time.Time) ([]Change, error)
+	// GetTrade returns the transactions ordered by index
+	// and ErrNotFound if the trade has no transactions
+	GetTrade(ctx context.Context, id uint64) (*aot.Trade, error)
+	// ListTrades returns a page of trades passing the filters of a valid
+	// query; the ingestion time of a trade is the updatedAt it was created
+	// with
+	ListTrades(ctx context.Context, q Query) (Page, error)
+	// DeleteTrade returns ErrNotFound if there is no such trade
+	DeleteTrade(ctx context.Context, id uint64) error
+}
@@ -0,0 +1,295 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"cryptobot_server/auth"
+	"cryptobot_server/hub"
+
+	"github.com/gorilla/websocket"
+)
+
+// Control actions a client may send over /ws
+const (
+	actionSubscribe   = "subscribe"
+	actionUnsubscribe = "unsubscribe"
+	actionList        = "list"
+	actionResume      = "resume"
+	actionAuth        = "auth"
+)
+
+// Codes of error frames
+const (
+	// codeSnapshotRequired tells a resuming client that the frames it missed
+	// are gone and it has to subscribe again to get a snapshot
+	codeSnapshotRequired = "snapshot_required"
+	// codeUnauthorized is sent before the connection is closed if the client
+	// didn't authenticate or its token is invalid
+	codeUnauthorized = "unauthorized"
+	// codeForbidden lists the topics or exchanges the client may not
+	// subscribe to
+	codeForbidden = "forbidden"
+)
+
+// Types of frames the server sends in reply to control frames
+const (
+	frameAck   = "ack"
+	frameError = "error"
+)
+
+// controlFrame is a request sent by the client, e.g.
+//
+//	{"action": "subscribe", "topics": ["orderbook"], "id": "1",
+//	 "filters": [{"exchange": "BYBIT", "trading_pair": "BTCUSDT", "market_type": "FUTURES"}]}
+//
+// A message is delivered if it passes at least one of the filters. On
+// subscribe the client first gets a snapshot frame per topic with the latest
+// message of every instrument; live messages and the ack follow it.
+//
+// After a reconnect the client resumes instead, giving the epoch and the
+// last sequence number it has seen per topic, and gets the missed messages:
+//
+//	{"action": "resume", "epoch": "m2k1x9c0", "last_seq": {"trade": 120, "orderbook": 5310}}
+//
+// Sequence numbers start again after a restart of the server, then the
+// epoch of the frames changes and the topics have to be subscribed again.
+//
+// If authentication is enabled and the handshake carried no token, the
+// first frame has to authenticate the client, otherwise it is disconnected:
+//
+//	{"action": "auth", "token": "<API key or JWT>"}
+type controlFrame struct {
+	Action  string            `json:"action"`
+	Topics  []string          `json:"topics,omitempty"`
+	Filters []hub.Filter      `json:"filters,omitempty"`
+	LastSeq map[string]uint64 `json:"last_seq,omitempty"`
+	Epoch   string            `json:"epoch,omitempty"`
+	Token   string            `json:"token,omitempty"`
+	ID      string            `json:"id,omitempty"`
+}
+
+// replyFrame is the server answer to a control frame. ID echoes the request id.
+type replyFrame struct {
+	Type       string   `json:"type"`
+	Action     string   `json:"action,omitempty"`
+	ID         string   `json:"id,omitempty"`
+	Topics     []string `json:"topics,omitempty"`
+	Exchanges  []string `json:"exchanges,omitempty"`
+	Subscribed []string `json:"subscribed"`
+	Error      string   `json:"error,omitempty"`
+	Code       string   `json:"code,omitempty"`
+}
+
+func (s *Server) handleControlFrame(c *client, message []byte) {
+	h := s.hub
+	var frame controlFrame
+	if err := json.Unmarshal(message, &frame); err != nil {
+		reply(c, replyFrame{Type: frameError, Error: fmt.Sprintf("invalid control frame: %v", err)})
+		return
+	}
+
+	if frame.Action == actionAuth {
+		s.handleAuth(c, frame)
+		return
+	}
+	if !s.authenticated(c) {
+		replyClose(c, replyFrame{
+			Type:   frameError,
+			Action: frame.Action,
+			ID:     frame.ID,
+			Error:  "the first frame must be an auth frame",
+			Code:   codeUnauthorized,
+		})
+		return
+	}
+
+	switch frame.Action {
+	case actionSubscribe:
+		if len(frame.Topics) == 0 {
+			replyError(c, h, frame, "no topics given")
+			return
+		}
+		if s.forbidden(c, frame, frame.Topics) {
+			return
+		}
+		if err := h.Subscribe(c, frame.Filters, frame.Topics...); err != nil {
+			replyError(c, h, frame, err.Error())
+			return
+		}
+		replyAck(c, h, frame, frame.Topics)
+	case actionUnsubscribe:
+		if len(frame.Topics) == 0 {
+			replyError(c, h, frame, "no topics given")
+			return
+		}
+		h.Unsubscribe(c, frame.Topics...)
+		replyAck(c, h, frame, frame.Topics)
+	case actionList:
+		replyAck(c, h, frame, h.Topics())
+	case actionResume:
+		s.handleResume(c, frame)
+	default:
+		replyError(c, h, frame, fmt.Sprintf("unknown action: %q", frame.Action))
+	}
+}
+
+// handleResume replays the missed messages of every topic; topics which can't
+// be resumed are reported in one error frame with codeSnapshotRequired
+func (s *Server) handleResume(c *client, frame controlFrame) {
+	h := s.hub
+	if len(frame.LastSeq) == 0 {
+		replyError(c, h, frame, "no last_seq given")
+		return
+	}
+
+	topics := make([]string, 0, len(frame.LastSeq))
+	for topic := range frame.LastSeq {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	if s.forbidden(c, frame, topics) {
+		return
+	}
+
+	var resumed, expired []string
+	for _, topic := range topics {
+		err := h.Resume(c, frame.Filters, topic, frame.Epoch, frame.LastSeq[topic])
+		switch {
+		case err == nil:
+			resumed = append(resumed, topic)
+		case errors.Is(err, hub.ErrSnapshotRequired):
+			expired = append(expired, topic)
+		default:
+			replyError(c, h, frame, err.Error())
+			return
+		}
+	}
+
+	if len(expired) > 0 {
+		reply(c, replyFrame{
+			Type:       frameError,
+			Action:     frame.Action,
+			ID:         frame.ID,
+			Topics:     expired,
+			Subscribed: h.Subscriptions(c),
+			Error:      hub.ErrSnapshotRequired.Error(),
+			Code:       codeSnapshotRequired,
+		})
+	}
+	if len(resumed) > 0 {
+		replyAck(c, h, frame, resumed)
+	}
+}
+
+// handleAuth authenticates a client which connected without a token; a
+// failure closes the connection
+func (s *Server) handleAuth(c *client, frame controlFrame) {
+	if s.options.Auth == nil || c.principal.Load() != nil {
+		replyError(c, s.hub, frame, "already authenticated")
+		return
+	}
+	p, err := s.options.Auth.Authenticate(frame.Token)
+	if err == nil && !p.Allows(auth.ScopeStream) {
+		err = fmt.Errorf("missing scope %s", auth.ScopeStream)
+	}
+	if err != nil {
+		log.Printf("WebSocket client %d failed to authenticate: %v", c.id, err)
+		replyClose(c, replyFrame{
+			Type:   frameError,
+			Action: frame.Action,
+			ID:     frame.ID,
+			Error:  err.Error(),
+			Code:   codeUnauthorized,
+		})
+		return
+	}
+	c.principal.Store(p)
+	replyAck(c, s.hub, frame, nil)
+}
+
+// forbidden replies with an error frame listing the topics and the
+// exchanges of the filters the client may not subscribe to, if any; nothing
+// is subscribed then
+func (s *Server) forbidden(c *client, frame controlFrame, topics []string) bool {
+	var deniedTopics, deniedExchanges []string
+	for _, topic := range topics {
+		if !s.allows(c, topic) {
+			deniedTopics = append(deniedTopics, topic)
+		}
+	}
+	for _, filter := range frame.Filters {
+		if !s.allowsExchange(c, filter.Exchange) {
+			deniedExchanges = append(deniedExchanges, filter.Exchange)
+		}
+	}
+	if len(deniedTopics) == 0 && len(deniedExchanges) == 0 {
+		return false
+	}
+
+	msg := "not allowed to subscribe to these topics"
+	if len(deniedTopics) == 0 {
+		msg = "not allowed to subscribe to these exchanges"
+	}
+	reply(c, replyFrame{
+		Type:       frameError,
+		Action:     frame.Action,
+		ID:         frame.ID,
+		Topics:     deniedTopics,
+		Exchanges:  deniedExchanges,
+		Subscribed: s.hub.Subscriptions(c),
+		Error:      msg,
+		Code:       codeForbidden,
+	})
+	return true
+}
+
+func replyAck(c *client, h *hub.Hub, frame controlFrame, topics []string) {
+	reply(c, replyFrame{
+		Type:       frameAck,
+		Action:     frame.Action,
+		ID:         frame.ID,
+		Topics:     topics,
+		Subscribed: h.Subscriptions(c),
+	})
+}
+
+func replyError(c *client, h *hub.Hub, frame controlFrame, msg string) {
+	reply(c, replyFrame{
+		Type:       frameError,
+		Action:     frame.Action,
+		ID:         frame.ID,
+		Subscribed: h.Subscriptions(c),
+		Error:      msg,
+	})
+}
+
+func reply(c *client, frame replyFrame) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		log.Println("Error marshalling control reply:", err)
+		return
+	}
+	c.enqueue(outbound{messageType: websocket.TextMessage, data: data})
+}
+
+// replyClose sends the frame and closes the connection with a policy
+// violation once it is written
+func replyClose(c *client, frame replyFrame) {
+	frame.Subscribed = []string{}
+	data, err := json.Marshal(frame)
+	if err != nil {
+		log.Println("Error marshalling control reply:", err)
+		c.close()
+		return
+	}
+	c.enqueue(outbound{
+		messageType: websocket.TextMessage,
+		data:        data,
+		closeCode:   websocket.ClosePolicyViolation,
+		closeText:   frame.Code,
+	})
+}
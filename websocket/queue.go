@@ -0,0 +1,136 @@
+package websocket
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Policies applied when the send queue of a slow client is full
+const (
+	// PolicyDropOldest drops the oldest queued data frame
+	PolicyDropOldest = "drop_oldest"
+	// PolicyCoalesce replaces a queued frame of the same instrument with the
+	// newer one, so only the latest order book of each pair is kept
+	PolicyCoalesce = "coalesce"
+	// PolicyDisconnect closes the connection of the client
+	PolicyDisconnect = "disconnect"
+)
+
+// ValidatePolicy checks the name of a slow-consumer policy
+func ValidatePolicy(policy string) error {
+	switch policy {
+	case PolicyDropOldest, PolicyCoalesce, PolicyDisconnect:
+		return nil
+	}
+	return fmt.Errorf("unknown slow-consumer policy: %q", policy)
+}
+
+// outbound is a frame queued for writing
+type outbound struct {
+	messageType int
+	data        []byte
+	// key identifies the instrument for PolicyCoalesce, empty means
+	// the frame is never coalesced
+	key string
+	// control frames are replies to the client and are never dropped
+	control bool
+	// closeCode closes the connection once the frame is written, e.g.
+	// after an authentication error
+	closeCode int
+	closeText string
+}
+
+// sendQueue is the bounded queue between the hub and the client writer
+type sendQueue struct {
+	mu        sync.Mutex
+	items     []outbound
+	size      int
+	policy    string
+	dropped   uint64
+	coalesced uint64
+	// ready has a value while the queue is not empty
+	ready chan struct{}
+}
+
+func newSendQueue(size int, policy string) *sendQueue {
+	return &sendQueue{
+		size:   size,
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// push queues a frame applying the policy, it returns false if the client
+// has to be disconnected
+func (q *sendQueue) push(message outbound) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.policy == PolicyCoalesce && message.key != "" {
+		for i := range q.items {
+			if q.items[i].key == message.key {
+				q.items[i] = message
+				q.coalesced++
+				return true
+			}
+		}
+	}
+
+	if !message.control && q.dataLen() >= q.size {
+		if q.policy == PolicyDisconnect {
+			q.dropped++
+			return false
+		}
+		q.dropOldest()
+	}
+
+	q.items = append(q.items, message)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pop takes all queued frames
+func (q *sendQueue) pop() []outbound {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.items
+	q.items = nil
+	return items
+}
+
+// stats returns the number of queued, dropped and coalesced frames
+func (q *sendQueue) stats() (int, uint64, uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items), q.dropped, q.coalesced
+}
+
+// room returns how many data frames can be queued before the policy applies
+func (q *sendQueue) room() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return max(q.size-q.dataLen(), 0)
+}
+
+func (q *sendQueue) dataLen() int {
+	n := 0
+	for _, item := range q.items {
+		if !item.control {
+			n++
+		}
+	}
+	return n
+}
+
+func (q *sendQueue) dropOldest() {
+	for i, item := range q.items {
+		if !item.control {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			q.dropped++
+			return
+		}
+	}
+}
@@ -3,54 +3,390 @@ package websocket
 import (
 	"log"
 	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"cryptobot_server/kafka"
+	"cryptobot_server/auth"
+	"cryptobot_server/hub"
 
+	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
+// Heartbeat settings: the server pings every pingPeriod and drops a client
+// which hasn't answered with a pong (or any other frame) within pongWait
+var (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = pongWait * 9 / 10
+	maxControlSize = int64(64 * 1024)
+	// authWait is how long a client connected without a token has to send
+	// the auth frame
+	authWait = 10 * time.Second
+)
+
+// Formats of data frames a client may ask for with ?format=
+const (
+	formatJSON     = "json"
+	formatProtobuf = "protobuf"
+)
+
+// Options of the WebSocket server
+type Options struct {
+	// QueueSize bounds the number of data frames waiting for a slow client
+	QueueSize int
+	// Policy applied when the queue is full, a client may override it with ?policy=
+	Policy string
+	// CheckOrigin decides which browser origins may connect, nil allows
+	// only the origin of the server itself
+	CheckOrigin func(r *http.Request) bool
+	// Auth checks tokens of clients which didn't authenticate during the
+	// handshake, nil disables authentication. The handshake itself is
+	// checked by auth.Authenticator.Handshake in front of the server.
+	Auth *auth.Authenticator
+}
+
+// Server streams hub topics to WebSocket clients
+type Server struct {
+	hub      *hub.Hub
+	options  Options
+	upgrader websocket.Upgrader
+	nextID   atomic.Uint64
+
+	mu       sync.Mutex
+	clients  map[*client]struct{}
+	shutdown bool
+}
+
+func NewServer(h *hub.Hub, options Options) *Server {
+	return &Server{
+		hub:      h,
+		options:  options,
+		upgrader: websocket.Upgrader{CheckOrigin: options.CheckOrigin},
+		clients:  make(map[*client]struct{}),
+	}
+}
+
+// client is a single WebSocket connection subscribed to hub topics
+type client struct {
+	id          uint64
+	conn        *websocket.Conn
+	format      string
+	queue       *sendQueue
+	connectedAt time.Time
+	// principal is nil until the client authenticates if auth is enabled
+	principal atomic.Pointer[auth.Principal]
+	// restricted is set if auth is enabled, every frame is then checked
+	// against the topics and exchanges of the principal
+	restricted bool
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func newClient(id uint64, conn *websocket.Conn, format string, queue *sendQueue, restricted bool) *client {
+	return &client{
+		id:          id,
+		conn:        conn,
+		format:      format,
+		queue:       queue,
+		connectedAt: time.Now(),
+		restricted:  restricted,
+		done:        make(chan struct{}),
+	}
+}
+
+// receives reports whether the client may get the frame. Subscriptions are
+// checked too, but a subscription without an exchange filter still carries
+// messages of exchanges the roles of the client don't allow, and the
+// exchanges of a trade are only known from its transactions.
+func (c *client) receives(frame *hub.Frame) bool {
+	if !c.restricted {
 		return true
-	},
+	}
+	p := c.principal.Load()
+	return p != nil && p.AllowsMessage(frame.Topic, frame.AllExchanges())
+}
+
+// Send implements hub.Subscriber, it never blocks: a slow client is handled
+// by the policy of its send queue
+func (c *client) Send(frame *hub.Frame) {
+	if !c.receives(frame) {
+		return
+	}
+	message := outbound{messageType: websocket.TextMessage, data: frame.JSON, key: frame.Key}
+	if c.format == formatProtobuf {
+		message.messageType = websocket.BinaryMessage
+		message.data = frame.Binary
+	}
+	if message.key != "" {
+		message.key = frame.Topic + ":" + message.key
+	}
+	if !c.queue.push(message) {
+		log.Printf("WebSocket client %d is too slow, disconnecting", c.id)
+		// Closing writes a close frame, don't make the hub wait for it
+		go c.close()
+	}
+}
+
+// Room implements hub.Bounded, resuming clients get a snapshot instead of
+// replayed frames the queue would drop
+func (c *client) Room() int {
+	return c.queue.room()
 }
 
-// WebSocket handler
-func WSHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+// SendSnapshot implements hub.Subscriber. JSON clients get one snapshot
+// frame, protobuf clients get the latest envelopes one by one.
+func (c *client) SendSnapshot(topic, epoch string, seq uint64, frames []*hub.Frame) {
+	if c.restricted {
+		allowed := make([]*hub.Frame, 0, len(frames))
+		for _, frame := range frames {
+			if c.receives(frame) {
+				allowed = append(allowed, frame)
+			}
+		}
+		frames = allowed
+	}
+	if c.format == formatProtobuf {
+		for _, frame := range frames {
+			c.enqueue(outbound{messageType: websocket.BinaryMessage, data: frame.Binary})
+		}
+		return
+	}
+	data, err := hub.SnapshotJSON(topic, epoch, seq, frames)
+	if err != nil {
+		log.Printf("Error encoding snapshot of topic %s: %v", topic, err)
+		return
+	}
+	c.enqueue(outbound{messageType: websocket.TextMessage, data: data})
+}
+
+func (c *client) enqueue(message outbound) {
+	message.control = true
+	c.queue.push(message)
+}
+
+// close stops the writer, sends a close frame and closes the connection,
+// safe to call several times
+func (c *client) close() {
+	c.closeWith(websocket.CloseNormalClosure, "")
+}
+
+func (c *client) closeWith(code int, text string) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		message := websocket.FormatCloseMessage(code, text)
+		c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+		c.conn.Close()
+	})
+}
+
+// ServeHTTP upgrades the connection and streams messages of the shared hub.
+// Clients choose their topics with control frames, see protocol.go. Data
+// frames are JSON envelopes unless the client connects with ?format=protobuf,
+// then they are binary aot.Envelope messages, see aot.proto; control replies
+// are always JSON.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = formatJSON
+	case formatJSON, formatProtobuf:
+	default:
+		http.Error(w, "unsupported format: "+format, http.StatusBadRequest)
+		return
+	}
+
+	policy := r.URL.Query().Get("policy")
+	if policy == "" {
+		policy = s.options.Policy
+	}
+	if err := ValidatePolicy(policy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Error upgrading connection:", err)
 		return
 	}
-	defer conn.Close()
 
-	// Create a channel for sending WebSocket messages
-	messageChannel := make(chan []byte)
+	c := newClient(s.nextID.Add(1), conn, format, newSendQueue(s.options.QueueSize, policy), s.options.Auth != nil)
+	if p, ok := auth.FromContext(r.Context()); ok {
+		c.principal.Store(p)
+	}
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		c.closeWith(websocket.CloseGoingAway, "server shutting down")
+		return
+	}
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+
+	// Once the connection is gone the client leaves all its topics
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+	}()
+	defer c.close()
+	defer s.hub.Unregister(c)
 
 	// Start a goroutine for writing to the WebSocket
-	go writeToWebSocket(conn, messageChannel)
+	go writeToWebSocket(c)
 
-	// Setup Kafka consumer to subscribe to multiple topics concurrently
-	var wg sync.WaitGroup
-	topics := []string{"orderbook", "pnl", "wallet", "trade", "trade_dictionary"}
+	s.readFromWebSocket(c)
+}
 
-	for _, topic := range topics {
-		wg.Add(1)
-		go kafka.ConsumeMessages(topic, messageChannel, &wg)
+// authenticated reports whether the client may subscribe
+func (s *Server) authenticated(c *client) bool {
+	return s.options.Auth == nil || c.principal.Load() != nil
+}
+
+// allows reports whether the client may receive messages of the topic
+func (s *Server) allows(c *client, topic string) bool {
+	if s.options.Auth == nil {
+		return true
 	}
+	p := c.principal.Load()
+	return p != nil && p.Allows(auth.TopicScope(topic))
+}
 
-	// Wait for all consumers to finish
-	wg.Wait()
+// allowsExchange reports whether the client may receive messages of the
+// exchange
+func (s *Server) allowsExchange(c *client, exchange string) bool {
+	if s.options.Auth == nil {
+		return true
+	}
+	p := c.principal.Load()
+	return p != nil && p.AllowsExchange(exchange)
 }
 
-// Function to handle WebSocket writes
-func writeToWebSocket(conn *websocket.Conn, messageChannel chan []byte) {
-	for message := range messageChannel {
-		// Write the message to WebSocket connection
-		err := conn.WriteMessage(websocket.TextMessage, message)
+// Shutdown sends a going-away close frame to every client and refuses new
+// connections. The handlers of the closed connections return on their own.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	s.shutdown = true
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.closeWith(websocket.CloseGoingAway, "server shutting down")
+	}
+	log.Printf("Closed %d WebSocket connections", len(clients))
+}
+
+// ClientStats describes a connected client and its send queue
+type ClientStats struct {
+	ID          uint64    `json:"id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	Subject     string    `json:"subject,omitempty"`
+	Format      string    `json:"format"`
+	Policy      string    `json:"policy"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Topics      []string  `json:"topics"`
+	Queued      int       `json:"queued"`
+	Dropped     uint64    `json:"dropped"`
+	Coalesced   uint64    `json:"coalesced"`
+}
+
+// Clients returns the stats of all connected clients ordered by ID
+func (s *Server) Clients() []ClientStats {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	stats := make([]ClientStats, 0, len(clients))
+	for _, c := range clients {
+		queued, dropped, coalesced := c.queue.stats()
+		var subject string
+		if p := c.principal.Load(); p != nil {
+			subject = p.Subject
+		}
+		stats = append(stats, ClientStats{
+			ID:          c.id,
+			RemoteAddr:  c.conn.RemoteAddr().String(),
+			Subject:     subject,
+			Format:      c.format,
+			Policy:      c.queue.policy,
+			ConnectedAt: c.connectedAt,
+			Topics:      s.hub.Subscriptions(c),
+			Queued:      queued,
+			Dropped:     dropped,
+			Coalesced:   coalesced,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// ClientsHandler lists connected clients with their counters: GET /admin/ws/clients
+func (s *Server) ClientsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, s.Clients())
+}
+
+// Function to handle WebSocket reads, returns when the connection is closed
+// by the peer or no frame, including pongs, arrived within pongWait
+func (s *Server) readFromWebSocket(c *client) {
+	c.conn.SetReadLimit(maxControlSize)
+	if s.authenticated(c) {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	} else {
+		c.conn.SetReadDeadline(time.Now().Add(authWait))
+	}
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			log.Println("Error sending message over WebSocket:", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println("Error reading from WebSocket:", err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		s.handleControlFrame(c, message)
+	}
+}
+
+// Function to handle WebSocket writes and periodic pings
+func writeToWebSocket(c *client) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	defer c.close()
+	for {
+		select {
+		case <-c.queue.ready:
+			for _, message := range c.queue.pop() {
+				// Write the message to WebSocket connection
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				err := c.conn.WriteMessage(message.messageType, message.data)
+				if err != nil {
+					log.Println("Error sending message over WebSocket:", err)
+					return
+				}
+				if message.closeCode != 0 {
+					c.closeWith(message.closeCode, message.closeText)
+					return
+				}
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				log.Println("Error sending ping over WebSocket:", err)
+				return
+			}
+		case <-c.done:
 			return
 		}
 	}